@@ -0,0 +1,200 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// How many nicknames the database of the lookup benchmarks has.
+const benchNickNameCount = 10000
+
+// Builds a database of benchNickNameCount nicknames with two entrys each and returns its nicknames.
+func buildBenchDatabase(b *testing.B) (dbPath string, nicknames []string, remove func()) {
+	logs := testLogs{"01.08.2020": make(map[string][]string, benchNickNameCount)}
+	nicknames = make([]string, benchNickNameCount)
+	for i := range nicknames {
+		nicknames[i] = fmt.Sprintf("Nick_Name%05d", i)
+		logs["01.08.2020"][nicknames[i]] = []string{
+			testLogLine("01.08.2020 10:00:00", fmt.Sprintf("10.0.%d.%d", i/256, i%256), "Xiaomi/begonia/begonia:10/QP1A.190711.020", "1.1.1.1:7777"),
+			testLogLine("01.08.2020 21:00:00", fmt.Sprintf("10.1.%d.%d", i/256, i%256), "Xiaomi/begonia/begonia:10/QP1A.190711.020", "2.2.2.2:7777"),
+		}
+	}
+	dbPath, remove = buildTestDatabase(b, logs)
+	return dbPath, nicknames, remove
+}
+
+// Runs fn on the database opened with the os.File.ReadAt and with the memory-mapped read path.
+func runReadPathBenchmarks(b *testing.B, dbPath string, fn func(b *testing.B, mldb *MordorLogsDB)) {
+	for _, path := range []struct {
+		name string
+		open func(dirPath string) (*MordorLogsDB, error)
+	}{{"ReadAt", OpenReadOnly}, {"Mmap", NewMappedMordorLogsDB}} {
+		b.Run(path.name, func(b *testing.B) {
+			mldb, err := path.open(dbPath)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer mldb.Close()
+			fn(b, mldb)
+		})
+	}
+}
+
+func BenchmarkFindDataByNickName(b *testing.B) {
+	dbPath, nicknames, remove := buildBenchDatabase(b)
+	defer remove()
+
+	runReadPathBenchmarks(b, dbPath, func(b *testing.B, mldb *MordorLogsDB) {
+		// Every nickname has the same entrys apart from the IP, so they are read as many bytes.
+		entrys, err := mldb.FindDataByNickName(nicknames[0])
+		if err != nil {
+			b.Fatal(err)
+		}
+		bytesPerOp := int64(firstIndexEntrySize + len(nicknames[0]) + 4 + 8*len(entrys))
+		for _, data := range entrys {
+			record, err := encodeDataEntry(nicknames[0], data)
+			if err != nil {
+				b.Fatal(err)
+			}
+			bytesPerOp += int64(len(record))
+		}
+
+		b.ReportAllocs()
+		b.SetBytes(bytesPerOp)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := mldb.FindDataByNickName(nicknames[i%len(nicknames)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkFirstIndexIterator(b *testing.B) {
+	dbPath, nicknames, remove := buildBenchDatabase(b)
+	defer remove()
+
+	runReadPathBenchmarks(b, dbPath, func(b *testing.B, mldb *MordorLogsDB) {
+		b.ReportAllocs()
+		b.SetBytes(int64(firstIndexEntrySize + len(nicknames[0])))
+		b.ResetTimer()
+		it := mldb.FirstIndexIterator()
+		for i := 0; i < b.N; i++ {
+			if _, _, err := it.Next(); err == ErrIterationDone {
+				it = mldb.FirstIndexIterator()
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// Runs fn on an empty database written with and without the bulk writer.
+func runWritePathBenchmarks(b *testing.B, fn func(b *testing.B, mldb *MordorLogsDB)) {
+	for _, bulk := range []bool{false, true} {
+		name := "Direct"
+		if bulk {
+			name = "Bulk"
+		}
+		b.Run(name, func(b *testing.B) {
+			dirPath, err := ioutil.TempDir("", "mordorlogs-bench-")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dirPath)
+			mldb, _, err := NewMordorLogsDB(filepath.Join(dirPath, "db"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer mldb.Close()
+			if bulk {
+				mldb.EnableBulkWrite(DefaultBulkWriteBufferSize)
+			}
+			fn(b, mldb)
+			if err := mldb.Flush(); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+var benchDataEntry = DataEntry{
+	Time:        time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC),
+	IP:          net.IPv4(10, 0, 0, 1),
+	Android:     "10",
+	Brand:       "Xiaomi",
+	Model:       "Redmi Note 8 Pro",
+	Fingerprint: "Xiaomi/begonia/begonia:10/QP1A.190711.020/V12.0.3.0.QGGMIXM:user/release-keys",
+	Server:      "1.1.1.1:7777",
+}
+
+// Nicknames written by the write benchmarks, made up front so the loop only measures the writes.
+var benchWriteNickNames = func() []string {
+	nicknames := make([]string, 1024)
+	for i := range nicknames {
+		nicknames[i] = fmt.Sprintf("Nick_Name%05d", i)
+	}
+	return nicknames
+}()
+
+// Returns the bytes written to every file for a nickname with count entrys.
+func benchWriteBytes(b *testing.B, nickname string, count int) int64 {
+	record, err := encodeDataEntry(nickname, &benchDataEntry)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return int64(count*len(record) + 4 + 8*count + firstIndexEntrySize + len(nickname))
+}
+
+func BenchmarkWrite(b *testing.B) {
+	runWritePathBenchmarks(b, func(b *testing.B, mldb *MordorLogsDB) {
+		b.ReportAllocs()
+		b.SetBytes(benchWriteBytes(b, benchWriteNickNames[0], 1))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := mldb.Write(benchWriteNickNames[i%len(benchWriteNickNames)], benchDataEntry); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkWriteAll(b *testing.B) {
+	entrys := make([]*DataEntry, 16)
+	for i := range entrys {
+		entrys[i] = &benchDataEntry
+	}
+	runWritePathBenchmarks(b, func(b *testing.B, mldb *MordorLogsDB) {
+		b.ReportAllocs()
+		b.SetBytes(benchWriteBytes(b, benchWriteNickNames[0], len(entrys)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := mldb.WriteAll(benchWriteNickNames[i%len(benchWriteNickNames)], entrys); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,426 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// Options of the build, append, reindex and migrate commands.
+type BuildOptions struct {
+	// Approximate number of bytes the sorting stages may keep in memory.
+	MemoryLimit int
+	// See DefaultClusterMaxShared.
+	ClusterMaxShared int
+	// Number of goroutines parsing log files, runtime.NumCPU() if not set.
+	Workers int
+}
+
+const DefaultMemoryLimit = 512 * 1024 * 1024
+
+// Runs the whole pipeline from logParser.go in temporary directories next to outPath
+// and replaces outPath with the finished database only when every stage has succeeded.
+func BuildDatabase(logsDirPath string, outPath string, options BuildOptions) error {
+	outPath = filepath.Clean(outPath)
+	tmpPath, err := ioutil.TempDir(filepath.Dir(outPath), "."+filepath.Base(outPath)+".build-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpPath)
+
+	buildStart := time.Now()
+
+	sortedPath, err := buildSortedDatabase("ConvertLogsToDatabase", convertLogs(logsDirPath, options), tmpPath, 7, options)
+	if err != nil {
+		return err
+	}
+
+	if err := buildKeyIndexes(sortedPath, tmpPath, 5, 7, options); err != nil {
+		return err
+	}
+	if err := buildClusters(sortedPath, 6, 7, options); err != nil {
+		return err
+	}
+	if err := runBuildStage(7, 7, "SealDatabase", func() error { return SealDatabase(sortedPath) }); err != nil {
+		return err
+	}
+
+	if err := replaceDir(sortedPath, outPath); err != nil {
+		return err
+	}
+
+	log.Printf("Database %s built in %s.", outPath, time.Since(buildStart).Round(time.Millisecond))
+	return nil
+}
+
+// Parses only the logs in logsDirPath and merges them into the existing database at dbPath.
+// The database is replaced the same way as in BuildDatabase.
+func AppendLogsToDatabase(logsDirPath string, dbPath string, options BuildOptions) error {
+	dbPath = filepath.Clean(dbPath)
+	if err := recoverReplacedDir(dbPath); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dbPath, "first_index.bin")); err != nil {
+		return err
+	}
+	tmpPath, err := ioutil.TempDir(filepath.Dir(dbPath), "."+filepath.Base(dbPath)+".append-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpPath)
+
+	appendStart := time.Now()
+
+	freshPath, err := buildSortedDatabase("ConvertLogsToDatabase", convertLogs(logsDirPath, options), tmpPath, 8, options)
+	if err != nil {
+		return err
+	}
+
+	mergedPath := filepath.Join(tmpPath, "merged")
+	err = runBuildStage(5, 8, "MergeDatabases", func() error {
+		return withDatabase(dbPath, func(base *MordorLogsDB) error {
+			return withDatabases(freshPath, mergedPath, func(fresh *MordorLogsDB, to *MordorLogsDB) error {
+				if err := MergeDatabases(base, fresh, to); err != nil {
+					return err
+				}
+				log.Println("Nicknames:", base.GetEntryCount(), "+", fresh.GetEntryCount(), "=>", to.GetEntryCount())
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := buildKeyIndexes(mergedPath, tmpPath, 6, 8, options); err != nil {
+		return err
+	}
+	if err := buildClusters(mergedPath, 7, 8, options); err != nil {
+		return err
+	}
+	if err := runBuildStage(8, 8, "SealDatabase", func() error { return SealDatabase(mergedPath) }); err != nil {
+		return err
+	}
+
+	if err := replaceDir(mergedPath, dbPath); err != nil {
+		return err
+	}
+
+	log.Printf("Logs appended to %s in %s.", dbPath, time.Since(appendStart).Round(time.Millisecond))
+	return nil
+}
+
+// Regenerates first_index.bin with its nickname heap and second_index.bin of dbPath from its data.bin alone,
+// for example when they are lost or damaged. The other files are kept as they are.
+func ReindexDatabase(dbPath string, options BuildOptions) error {
+	if options.MemoryLimit <= 0 {
+		options.MemoryLimit = DefaultMemoryLimit
+	}
+	dbPath = filepath.Clean(dbPath)
+	if err := recoverReplacedDir(dbPath); err != nil {
+		return err
+	}
+	var data DataFile
+	if err := data.OpenReadOnly(filepath.Join(dbPath, "data.bin")); err != nil {
+		return err
+	}
+	defer data.Close()
+
+	tmpPath, err := ioutil.TempDir(filepath.Dir(dbPath), "."+filepath.Base(dbPath)+".reindex-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpPath)
+
+	reindexStart := time.Now()
+
+	indexPath := filepath.Join(tmpPath, "indexes")
+	if err := os.Mkdir(indexPath, 0755); err != nil {
+		return err
+	}
+	fileNames := []string{"first_index.bin", "second_index.bin", nicknameHeapPath("first_index.bin")}
+
+	err = runBuildStage(1, 2, "RebuildIndexes", func() error {
+		return RebuildIndexes(&data, filepath.Join(indexPath, fileNames[0]), filepath.Join(indexPath, fileNames[1]), tmpPath, options.MemoryLimit)
+	})
+	if err != nil {
+		return err
+	}
+	err = runBuildStage(2, 2, "SealDatabase", func() error {
+		for _, name := range fileNames {
+			if err := WriteFileChecksum(filepath.Join(indexPath, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range fileNames {
+		if err := os.Rename(filepath.Join(indexPath, name), filepath.Join(dbPath, name)); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Indexes of %s rebuilt in %s.", dbPath, time.Since(reindexStart).Round(time.Millisecond))
+	return nil
+}
+
+func convertLogs(logsDirPath string, options BuildOptions) func(raw *MordorLogsDB) error {
+	if options.Workers <= 0 {
+		options.Workers = runtime.NumCPU()
+	}
+	return func(raw *MordorLogsDB) error {
+		return ConvertLogsToDatabase(logsDirPath, raw, options.Workers)
+	}
+}
+
+// Runs the four conversion stages inside tmpPath and returns the directory of the sorted database.
+// The first stage, named convertName, fills the raw database with convert.
+func buildSortedDatabase(convertName string, convert func(raw *MordorLogsDB) error, tmpPath string, stageCount int, options BuildOptions) (string, error) {
+	if options.MemoryLimit <= 0 {
+		options.MemoryLimit = DefaultMemoryLimit
+	}
+
+	rawPath := filepath.Join(tmpPath, "raw")
+	groupedPath := filepath.Join(tmpPath, "grouped")
+	sortedPath := filepath.Join(tmpPath, "sorted")
+
+	err := runBuildStage(1, stageCount, convertName, func() error {
+		raw, err := createDatabase(rawPath)
+		if err != nil {
+			return err
+		}
+		if err := convert(raw); err != nil {
+			raw.Close()
+			return err
+		}
+		log.Println("Records parsed:", raw.GetEntryCount())
+		return closeDatabase(raw)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = runBuildStage(2, stageCount, "ExternalSortDatabase", func() error {
+		return withDatabases(rawPath, groupedPath, func(raw *MordorLogsDB, grouped *MordorLogsDB) error {
+			return ExternalSortDatabase(raw, grouped, tmpPath, options.MemoryLimit)
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Mkdir(sortedPath, 0755); err != nil {
+		return "", err
+	}
+
+	err = runBuildStage(3, stageCount, "ExternalSortFirstIndex", func() error {
+		return withDatabase(groupedPath, func(grouped *MordorLogsDB) error {
+			log.Println("Nicknames:", grouped.GetEntryCount())
+			return ExternalSortFirstIndex(grouped, filepath.Join(sortedPath, "first_index.bin"), tmpPath, options.MemoryLimit)
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = runBuildStage(4, stageCount, "SortSecondIndex", func() error {
+		return withDatabase(groupedPath, func(grouped *MordorLogsDB) error {
+			return SortSecondIndex(grouped, filepath.Join(sortedPath, "second_index.bin"))
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// The sorted indexes still point into the data file of the grouped database.
+	if err := os.Rename(filepath.Join(groupedPath, "data.bin"), filepath.Join(sortedPath, "data.bin")); err != nil {
+		return "", err
+	}
+
+	// Make sure the result can be opened before it replaces anything.
+	if err := withDatabase(sortedPath, func(*MordorLogsDB) error { return nil }); err != nil {
+		return "", fmt.Errorf("built database is unusable: %w", err)
+	}
+
+	return sortedPath, nil
+}
+
+func buildKeyIndexes(dbPath string, tmpPath string, stage int, stageCount int, options BuildOptions) error {
+	if options.MemoryLimit <= 0 {
+		options.MemoryLimit = DefaultMemoryLimit
+	}
+	return runBuildStage(stage, stageCount, "BuildKeyIndexes", func() error {
+		return withDatabase(dbPath, func(mldb *MordorLogsDB) error {
+			if err := BuildKeyIndexes(mldb, dbPath, tmpPath, options.MemoryLimit); err != nil {
+				return err
+			}
+			return BuildFoldedIndex(mldb, filepath.Join(dbPath, "folded_index.bin"), tmpPath, options.MemoryLimit)
+		})
+	})
+}
+
+func buildClusters(dbPath string, stage int, stageCount int, options BuildOptions) error {
+	if options.ClusterMaxShared <= 0 {
+		options.ClusterMaxShared = DefaultClusterMaxShared
+	}
+	return runBuildStage(stage, stageCount, "BuildClusters", func() error {
+		return withDatabase(dbPath, func(mldb *MordorLogsDB) error {
+			return BuildClusters(mldb, filepath.Join(dbPath, "cluster.bin"), options.ClusterMaxShared)
+		})
+	})
+}
+
+func runBuildStage(n int, count int, name string, stage func() error) error {
+	log.Printf("Stage %d/%d: %s...", n, count, name)
+	start := time.Now()
+	if err := stage(); err != nil {
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	log.Printf("Stage %d/%d: %s done in %s.", n, count, name, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// Creates an empty database for bulk writing, refusing to reuse existing files.
+func createDatabase(dirPath string) (*MordorLogsDB, error) {
+	mldb, isnew, err := NewMordorLogsDB(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	if !isnew {
+		mldb.Close()
+		return nil, fmt.Errorf("%s already contains a database", dirPath)
+	}
+	mldb.EnableBulkWrite(DefaultBulkWriteBufferSize)
+	return mldb, nil
+}
+
+func closeDatabase(mldb *MordorLogsDB) error {
+	if err := mldb.SyncFiles(); err != nil {
+		mldb.Close()
+		return err
+	}
+	return mldb.Close()
+}
+
+func withDatabase(dirPath string, fn func(*MordorLogsDB) error) error {
+	mldb, isnew, err := NewMordorLogsDB(dirPath)
+	if err != nil {
+		return err
+	}
+	if isnew {
+		mldb.Close()
+		return fmt.Errorf("%s does not contain a database", dirPath)
+	}
+	if err := fn(mldb); err != nil {
+		mldb.Close()
+		return err
+	}
+	return closeDatabase(mldb)
+}
+
+func withDatabases(fromPath string, toPath string, fn func(from *MordorLogsDB, to *MordorLogsDB) error) error {
+	return withDatabase(fromPath, func(from *MordorLogsDB) error {
+		to, err := createDatabase(toPath)
+		if err != nil {
+			return err
+		}
+		if err := fn(from, to); err != nil {
+			to.Close()
+			return err
+		}
+		return closeDatabase(to)
+	})
+}
+
+// Moves the directory at newPath to oldPath. An existing oldPath is moved aside to oldPath.old
+// first and restored if the final rename fails. The two renames are not atomic together: if the
+// process dies between them, only oldPath.old is left. OpenReadOnly then opens oldPath.old, and
+// the commands changing the database move it back with recoverReplacedDir before they start.
+func replaceDir(newPath string, oldPath string) error {
+	if err := recoverReplacedDir(oldPath); err != nil {
+		return err
+	}
+	backupPath := replacedDirPath(oldPath)
+	if _, err := os.Stat(oldPath); err == nil {
+		if err := os.RemoveAll(backupPath); err != nil {
+			return err
+		}
+		if err := os.Rename(oldPath, backupPath); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	} else {
+		backupPath = ""
+	}
+
+	if err := os.Rename(newPath, oldPath); err != nil {
+		if backupPath != "" {
+			os.Rename(backupPath, oldPath)
+		}
+		return err
+	}
+
+	if backupPath != "" {
+		return os.RemoveAll(backupPath)
+	}
+	return nil
+}
+
+// Returns the path replaceDir moves dirPath to while the new directory is renamed into place.
+func replacedDirPath(dirPath string) string {
+	return filepath.Clean(dirPath) + ".old"
+}
+
+// Moves dirPath.old back to dirPath when replaceDir was interrupted between its two renames.
+// An existing dirPath is complete, so a dirPath.old next to it is left for replaceDir to remove.
+func recoverReplacedDir(dirPath string) error {
+	if _, err := os.Stat(dirPath); !os.IsNotExist(err) {
+		return err
+	}
+	backupPath := replacedDirPath(dirPath)
+	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	log.Printf("Restoring %s from %s left by an interrupted replace.", dirPath, backupPath)
+	return os.Rename(backupPath, dirPath)
+}
+
+// Returns dirPath.old when replaceDir was interrupted and dirPath is missing, so readers
+// open the last complete database without changing anything.
+func readableDirPath(dirPath string) string {
+	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+		if _, err := os.Stat(replacedDirPath(dirPath)); err == nil {
+			return replacedDirPath(dirPath)
+		}
+	}
+	return dirPath
+}
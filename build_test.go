@@ -0,0 +1,378 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Log files of a test database: date directory => nickname => log lines.
+type testLogs map[string]map[string][]string
+
+func testLogLine(t string, ip string, fingerprint string, server string) string {
+	return fmt.Sprintf(">> [%s] Android: 10 | Brand: Xiaomi | Model: Mi 10 | FP: %s | IP: %s | Server: %s", t, fingerprint, ip, server)
+}
+
+// Mike_Tyson and Alice share a fingerprint, [ABC]_Bob and [ABC]_Carl share an IP address.
+var sampleTestLogs = testLogs{
+	"02.08.2020": {
+		"Mike_Tyson": {
+			testLogLine("02.08.2020 06:26:11", "10.0.2.4", "fp/dev4", "2.2.2.2:7777"),
+			testLogLine("02.08.2020 19:55:11", "10.0.0.2", "fp/dev4", "1.1.1.1:7777"),
+		},
+		"[ABC]_Bob": {
+			testLogLine("02.08.2020 10:00:00", "192.168.1.1", "fp/bob", "1.1.1.1:7777"),
+		},
+		"John_Smith": {
+			testLogLine("02.08.2020 21:04:48", "[2001:db8::1]", "fp/john", "1.1.1.1:7777"),
+		},
+	},
+	"05.08.2020": {
+		"Mike_Tyson": {
+			testLogLine("05.08.2020 12:00:00", "10.0.2.4", "fp/dev4", "2.2.2.2:7777"),
+		},
+		"[ABC]_Carl": {
+			testLogLine("05.08.2020 11:00:00", "192.168.1.1", "fp/carl", "1.1.1.1:7777"),
+		},
+		"Alice": {
+			testLogLine("05.08.2020 08:30:00", "2001:db8::2", "fp/dev4", "2.2.2.2:7777"),
+		},
+		"john_doe": {
+			testLogLine("05.08.2020 23:59:59", "172.16.0.1", "fp/doe", "1.1.1.1:7777"),
+		},
+	},
+}
+
+// Every nickname of sampleTestLogs with the times of its entrys.
+var sampleTestDatabase = map[string][]string{
+	"Alice":      {"05.08.2020 08:30:00"},
+	"John_Smith": {"02.08.2020 21:04:48"},
+	"Mike_Tyson": {"02.08.2020 06:26:11", "02.08.2020 19:55:11", "05.08.2020 12:00:00"},
+	"[ABC]_Bob":  {"02.08.2020 10:00:00"},
+	"[ABC]_Carl": {"05.08.2020 11:00:00"},
+	"john_doe":   {"05.08.2020 23:59:59"},
+}
+
+func writeTestLogs(t testing.TB, dirPath string, logs testLogs) {
+	for day, files := range logs {
+		dayPath := filepath.Join(dirPath, "client_log", day)
+		if err := os.MkdirAll(dayPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for nickname, lines := range files {
+			text := strings.Join(lines, "\n") + "\n"
+			if err := ioutil.WriteFile(filepath.Join(dayPath, nickname+".log"), []byte(text), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// Builds a database from logs inside a temporary directory, remove deletes the directory.
+func buildTestDatabase(t testing.TB, logs testLogs) (dbPath string, remove func()) {
+	dirPath, err := ioutil.TempDir("", "mordorlogs-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remove = func() { os.RemoveAll(dirPath) }
+	logsPath := filepath.Join(dirPath, "logs")
+	writeTestLogs(t, logsPath, logs)
+	dbPath = filepath.Join(dirPath, "db")
+	if err := BuildDatabase(logsPath, dbPath, BuildOptions{MemoryLimit: 1 << 20, Workers: 2}); err != nil {
+		remove()
+		t.Fatal(err)
+	}
+	return dbPath, remove
+}
+
+// The same as buildTestDatabase, but returns the database opened read-only.
+func openTestDatabase(t *testing.T, logs testLogs) (mldb *MordorLogsDB, remove func()) {
+	dbPath, removeDir := buildTestDatabase(t, logs)
+	mldb, err := OpenReadOnly(dbPath)
+	if err != nil {
+		removeDir()
+		t.Fatal(err)
+	}
+	return mldb, func() {
+		mldb.Close()
+		removeDir()
+	}
+}
+
+// Returns every nickname of the database with the times of its entrys, nicknames must be sorted.
+func readTestDatabase(t *testing.T, dbPath string) map[string][]string {
+	mldb, err := OpenReadOnly(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mldb.Close()
+	result := make(map[string][]string)
+	it := mldb.FirstIndexIterator()
+	prevNickName := ""
+	for {
+		nickname, offsetToSecondIndex, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if nickname <= prevNickName {
+			t.Errorf("%q follows %q in first_index.bin", nickname, prevNickName)
+		}
+		prevNickName = nickname
+		entrys, err := mldb.readDataBySecondIndex(offsetToSecondIndex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, data := range entrys {
+			result[nickname] = append(result[nickname], data.Time.UTC().Format(timeFormatLayout))
+		}
+	}
+	return result
+}
+
+// Returns the names inside the directory, sorted.
+func readTestDir(t *testing.T, dirPath string) []string {
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.Name()
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestBuildDatabase(t *testing.T) {
+	dbPath, remove := buildTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	if got := readTestDatabase(t, dbPath); !reflect.DeepEqual(got, sampleTestDatabase) {
+		t.Errorf("got %v, want %v", got, sampleTestDatabase)
+	}
+	// The temporary directories of the stages are removed.
+	if got, want := readTestDir(t, filepath.Dir(dbPath)), []string{"db", "logs"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("left %v next to the database, want %v", got, want)
+	}
+
+	// A failed stage leaves the existing database as it was.
+	err := BuildDatabase(filepath.Join(filepath.Dir(dbPath), "missing"), dbPath, BuildOptions{})
+	if err == nil || !strings.HasPrefix(err.Error(), "ConvertLogsToDatabase failed") {
+		t.Errorf("building from missing logs returned %v", err)
+	}
+	if got := readTestDatabase(t, dbPath); !reflect.DeepEqual(got, sampleTestDatabase) {
+		t.Errorf("after a failed build got %v, want %v", got, sampleTestDatabase)
+	}
+	if got, want := readTestDir(t, filepath.Dir(dbPath)), []string{"db", "logs"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("left %v next to the database after a failed build, want %v", got, want)
+	}
+}
+
+func TestReplaceDir(t *testing.T) {
+	tests := []struct {
+		name   string
+		newDir bool
+		oldDir bool
+		// The db.old left by a replace interrupted between its renames.
+		backupDir bool
+		wantErr   bool
+		want      []string // Files of the old directory afterwards.
+	}{
+		{"replace", true, true, false, false, []string{"new"}},
+		{"create", true, false, false, false, []string{"new"}},
+		{"missing new directory", false, true, false, true, []string{"old"}},
+		{"interrupted replace", true, false, true, false, []string{"new"}},
+		{"stale backup", true, true, true, false, []string{"new"}},
+		{"missing new directory after interrupted replace", false, false, true, true, []string{"old"}},
+	}
+	for _, test := range tests {
+		dirPath, err := ioutil.TempDir("", "mordorlogs-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		newPath := filepath.Join(dirPath, "new.db")
+		oldPath := filepath.Join(dirPath, "db")
+		for _, dir := range []struct {
+			create bool
+			path   string
+			file   string
+		}{{test.newDir, newPath, "new"}, {test.oldDir, oldPath, "old"}, {test.backupDir, oldPath + ".old", "old"}} {
+			if !dir.create {
+				continue
+			}
+			if err := os.Mkdir(dir.path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir.path, dir.file), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = replaceDir(newPath, oldPath)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+		if got := readTestDir(t, oldPath); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+		if got := readTestDir(t, dirPath); !reflect.DeepEqual(got, []string{"db"}) {
+			t.Errorf("%s: left %v", test.name, got)
+		}
+		os.RemoveAll(dirPath)
+	}
+}
+
+func TestInterruptedReplace(t *testing.T) {
+	dbPath, remove := buildTestDatabase(t, sampleTestLogs)
+	defer remove()
+	// The state after replaceDir moved the database aside and died before renaming the new one.
+	if err := os.Rename(dbPath, dbPath+".old"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers open db.old and leave it where it is.
+	if got := readTestDatabase(t, dbPath); !reflect.DeepEqual(got, sampleTestDatabase) {
+		t.Errorf("read %v, want %v", got, sampleTestDatabase)
+	}
+	mldb, err := NewMappedMordorLogsDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mldb.Close()
+	if got, want := readTestDir(t, filepath.Dir(dbPath)), []string{"db.old", "logs"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("readers left %v, want %v", got, want)
+	}
+
+	// Commands changing the database move it back first.
+	if err := ReindexDatabase(dbPath, BuildOptions{MemoryLimit: 1 << 10}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readTestDir(t, filepath.Dir(dbPath)), []string{"db", "logs"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reindex left %v, want %v", got, want)
+	}
+	if got := readTestDatabase(t, dbPath); !reflect.DeepEqual(got, sampleTestDatabase) {
+		t.Errorf("after reindex got %v, want %v", got, sampleTestDatabase)
+	}
+}
+
+func TestAppendLogsToDatabase(t *testing.T) {
+	dbPath, remove := buildTestDatabase(t, testLogs{"02.08.2020": sampleTestLogs["02.08.2020"]})
+	defer remove()
+
+	withEarlierEntry := make(map[string][]string)
+	for nickname, times := range sampleTestDatabase {
+		withEarlierEntry[nickname] = times
+	}
+	withEarlierEntry["[ABC]_Bob"] = []string{"01.08.2020 09:00:00", "02.08.2020 10:00:00"}
+
+	tests := []struct {
+		name string
+		logs testLogs
+		want map[string][]string
+	}{
+		{"new day", testLogs{"05.08.2020": sampleTestLogs["05.08.2020"]}, sampleTestDatabase},
+		{"same day again", testLogs{"05.08.2020": sampleTestLogs["05.08.2020"]}, sampleTestDatabase},
+		{
+			"earlier entry of an existing nickname",
+			testLogs{"01.08.2020": {"[ABC]_Bob": {testLogLine("01.08.2020 09:00:00", "192.168.1.2", "fp/bob", "1.1.1.1:7777")}}},
+			withEarlierEntry,
+		},
+	}
+	for _, test := range tests {
+		logsPath, err := ioutil.TempDir("", "mordorlogs-test-logs-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestLogs(t, logsPath, test.logs)
+		err = AppendLogsToDatabase(logsPath, dbPath, BuildOptions{MemoryLimit: 1 << 20, Workers: 2})
+		os.RemoveAll(logsPath)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := readTestDatabase(t, dbPath); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// Reads every entry of the iterator as "nickname time IP".
+func readTestEntrys(t *testing.T, it EntryIterator) []string {
+	entrys := make([]string, 0)
+	for {
+		nickname, data, err := it.Next()
+		if err == ErrIterationDone {
+			return entrys
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		entrys = append(entrys, nickname+" "+data.Time.UTC().Format(timeFormatLayout)+" "+data.IP.String())
+	}
+}
+
+func TestReindexDatabase(t *testing.T) {
+	dbPath, remove := buildTestDatabase(t, sampleTestLogs)
+	defer remove()
+	want := readTestDatabase(t, dbPath)
+
+	tests := []struct {
+		name   string
+		damage func(dbPath string) error
+	}{
+		{"intact", func(string) error { return nil }},
+		{"indexes removed", func(dbPath string) error {
+			for _, name := range []string{"first_index.bin", "second_index.bin", nicknameHeapPath("first_index.bin")} {
+				if err := os.Remove(filepath.Join(dbPath, name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"second index emptied", func(dbPath string) error {
+			return os.Truncate(filepath.Join(dbPath, "second_index.bin"), fileHeaderSize)
+		}},
+	}
+	for _, test := range tests {
+		copyPath := copyTestDatabase(t, dbPath)
+		if err := test.damage(copyPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := ReindexDatabase(copyPath, BuildOptions{MemoryLimit: 1 << 10}); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			os.RemoveAll(copyPath)
+			continue
+		}
+		if got := readTestDatabase(t, copyPath); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, want)
+		}
+		result, err := VerifyDatabase(copyPath, func(c Corruption) { t.Errorf("%s: %s", test.name, c.String()) })
+		if err != nil || result.NickNames != len(want) {
+			t.Errorf("%s: verify checked %d nicknames, error %v", test.name, result.NickNames, err)
+		}
+		os.RemoveAll(copyPath)
+	}
+}
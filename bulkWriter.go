@@ -0,0 +1,85 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "os"
+
+// Buffer size per file used by the build pipeline.
+const DefaultBulkWriteBufferSize = 1024 * 1024
+
+// Collects appends to the end of a file in memory and writes them with a single WriteAt.
+// Offsets of the written entrys are still counted by the file, the buffer only delays the writes.
+type bulkWriter struct {
+	file   *os.File
+	offset int64 // File offset of the first buffered byte.
+	buf    []byte
+}
+
+func newBulkWriter(file *os.File, size int) *bulkWriter {
+	return &bulkWriter{file: file, buf: make([]byte, 0, size)}
+}
+
+// Writes b at offset, which must follow the previously written bytes.
+func (m *bulkWriter) WriteAt(b []byte, offset int64) error {
+	if len(m.buf) == 0 {
+		m.offset = offset
+	} else if m.offset+int64(len(m.buf)) != offset {
+		return ErrCorrupted
+	}
+	if len(m.buf)+len(b) > cap(m.buf) {
+		if err := m.Flush(); err != nil {
+			return err
+		}
+		m.offset = offset
+		if len(b) > cap(m.buf) {
+			_, err := m.file.WriteAt(b, offset)
+			return err
+		}
+	}
+	m.buf = append(m.buf, b...)
+	return nil
+}
+
+func (m *bulkWriter) Flush() error {
+	if len(m.buf) == 0 {
+		return nil
+	}
+	if _, err := m.file.WriteAt(m.buf, m.offset); err != nil {
+		return err
+	}
+	m.offset += int64(len(m.buf))
+	m.buf = m.buf[:0]
+	return nil
+}
+
+// Writes b at offset directly or through the bulk writer.
+func writeAt(file *os.File, bulk *bulkWriter, b []byte, offset int64) error {
+	if bulk != nil {
+		return bulk.WriteAt(b, offset)
+	}
+	_, err := file.WriteAt(b, offset)
+	return err
+}
+
+func flushBulkWriter(bulk *bulkWriter) error {
+	if bulk == nil {
+		return nil
+	}
+	return bulk.Flush()
+}
@@ -0,0 +1,102 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBulkWriter(t *testing.T) {
+	tests := []struct {
+		name       string
+		bufferSize int
+		writes     []int // Sizes of the writes, 0 flushes the writer.
+	}{
+		{"fits into buffer", 64, []int{3, 5, 7}},
+		{"exact buffer", 8, []int{4, 4, 4, 4}},
+		{"larger than buffer", 8, []int{3, 20, 2, 9}},
+		{"flush between writes", 16, []int{5, 0, 6, 0, 0, 7}},
+		{"one byte buffer", 1, []int{1, 2, 1, 0, 3}},
+	}
+	for _, test := range tests {
+		file, err := ioutil.TempFile("", "mordorlogs-test-bulk-")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The file starts with a header written directly, like every database file.
+		want := []byte("HEADER")
+		if _, err := file.WriteAt(want, 0); err != nil {
+			t.Fatal(err)
+		}
+		bulk := newBulkWriter(file, test.bufferSize)
+		offset := int64(len(want))
+		for i, size := range test.writes {
+			if size == 0 {
+				if err := bulk.Flush(); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			b := bytes.Repeat([]byte{byte('a' + i)}, size)
+			if err := writeAt(file, bulk, b, offset); err != nil {
+				t.Fatalf("%s: write %d: %v", test.name, i, err)
+			}
+			want = append(want, b...)
+			offset += int64(size)
+		}
+		if err := flushBulkWriter(bulk); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: file is %q, want %q", test.name, got, want)
+		}
+		file.Close()
+		os.Remove(file.Name())
+	}
+}
+
+func TestBulkWriterRejectsGaps(t *testing.T) {
+	file, err := ioutil.TempFile("", "mordorlogs-test-bulk-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	bulk := newBulkWriter(file, 16)
+	if err := bulk.WriteAt([]byte("abc"), 10); err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range []int64{0, 12, 14} {
+		if err := bulk.WriteAt([]byte("d"), offset); err != ErrCorrupted {
+			t.Errorf("write at %d after 10..13: error %v, want ErrCorrupted", offset, err)
+		}
+	}
+	if err := bulk.WriteAt([]byte("d"), 13); err != nil {
+		t.Errorf("write at 13 after 10..13: %v", err)
+	}
+}
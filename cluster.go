@@ -0,0 +1,562 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"sort"
+)
+
+/* Cluster file: Groups of nicknames (suspected alt accounts) linked by shared IP addresses or fingerprints.
+Nicknames without links don't belong to any cluster. The links are the evidence shown with the cluster.
+==============================ClusterFile=============================
+	NickNameCount		= 4 byte
+	ClusterCount		= 4 byte
+	ClusterID			= 4 byte // Repeated NickNameCount times, noClusterID if the nickname has no links.
+	MembersOffset		= 4 byte // Repeated ClusterCount + 1 times, number of the first member of the cluster.
+	Member				= 4 byte // Nickname IDs grouped by cluster.
+	LinksOffset			= 8 byte // Repeated ClusterCount + 1 times, from the end of LinksOffset.
+	Link				= ClusterLink // Links grouped by cluster.
+==============================ClusterFile=============================
+==============================ClusterLink=============================
+	Kind				= 1 byte // clusterLinkFingerprint or clusterLinkIP
+	ValueLength			= 1 byte
+	Value				= ValueLength bytes
+	NickNameCount		= 4 byte
+	NickNameID			= 4 byte // Repeated NickNameCount times.
+==============================ClusterLink=============================
+*/
+
+const noClusterID = ^uint32(0)
+
+// IP addresses and fingerprints shared by more nicknames are ignored: they are usually NAT addresses
+// of mobile carriers or default fingerprints and would merge unrelated players into one cluster.
+const DefaultClusterMaxShared = 16
+
+// Fingerprints are sorted before IP addresses.
+const (
+	clusterLinkFingerprint = 0
+	clusterLinkIP          = 1
+)
+
+var clusterHeaderMarker = [16]byte{'M', 'o', 'r', 'd', 'o', 'r', 'L', 'o', 'g', 's', 'D', 'B', 0x06, 0x06, 0x06, 0x06}
+
+type ClusterFile struct {
+	file          *os.File
+	nicknameCount uint32
+	clusterCount  uint32
+	memberCount   uint32
+}
+
+// The cluster file is written once by BuildClusters and only read afterwards.
+func (m *ClusterFile) Open(filePath string) (err error) {
+	m.file, err = os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	fh := NewFileHeader(clusterHeaderMarker)
+	if err := fh.readHeaderFromFile(m.file); err != nil {
+		return err
+	}
+	if !fh.checkVersion() {
+		return ErrIncompatibleVersions
+	}
+
+	b := make([]byte, 8)
+	if _, err := m.file.ReadAt(b, fileHeaderSize); err != nil {
+		return err
+	}
+	m.nicknameCount = binary.LittleEndian.Uint32(b[:4])
+	m.clusterCount = binary.LittleEndian.Uint32(b[4:8])
+	m.memberCount, err = m.readUint32At(m.membersOffsetsOffset() + int64(m.clusterCount)*4)
+	return err
+}
+
+func (m *ClusterFile) membersOffsetsOffset() int64 {
+	return fileHeaderSize + 8 + int64(m.nicknameCount)*4
+}
+
+func (m *ClusterFile) linksOffsetsOffset() int64 {
+	return m.membersOffsetsOffset() + int64(m.clusterCount+1)*4 + int64(m.memberCount)*4
+}
+
+func (m *ClusterFile) Close() error {
+	return m.file.Close()
+}
+
+func (m *ClusterFile) readUint32At(offset int64) (uint32, error) {
+	b := make([]byte, 4)
+	if _, err := m.file.ReadAt(b, offset); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (m *ClusterFile) readClusterID(nicknameID uint32) (uint32, error) {
+	if nicknameID >= m.nicknameCount {
+		return 0, ErrCorrupted
+	}
+	clusterID, err := m.readUint32At(fileHeaderSize + 8 + int64(nicknameID)*4)
+	if err != nil {
+		return 0, err
+	}
+	if clusterID == noClusterID {
+		return 0, ErrNoCluster
+	}
+	if clusterID >= m.clusterCount {
+		return 0, ErrCorrupted
+	}
+	return clusterID, nil
+}
+
+// Returns nickname IDs of all cluster members including the nickname itself.
+func (m *ClusterFile) ReadCluster(nicknameID uint32) ([]uint32, error) {
+	clusterID, err := m.readClusterID(nicknameID)
+	if err != nil {
+		return nil, err
+	}
+
+	membersOffsetsOffset := m.membersOffsetsOffset()
+	b := make([]byte, 8)
+	if _, err := m.file.ReadAt(b, membersOffsetsOffset+int64(clusterID)*4); err != nil {
+		return nil, err
+	}
+	first := binary.LittleEndian.Uint32(b[:4])
+	last := binary.LittleEndian.Uint32(b[4:8])
+	if last < first || last > m.memberCount {
+		return nil, ErrCorrupted
+	}
+
+	membersOffset := membersOffsetsOffset + int64(m.clusterCount+1)*4
+	members := make([]byte, (last-first)*4)
+	if _, err := m.file.ReadAt(members, membersOffset+int64(first)*4); err != nil {
+		return nil, err
+	}
+	nicknameIDs := make([]uint32, last-first)
+	for i := range nicknameIDs {
+		nicknameIDs[i] = binary.LittleEndian.Uint32(members[i*4:])
+	}
+	return nicknameIDs, nil
+}
+
+// Returns the links of the cluster of the nickname, written by BuildClusters.
+func (m *ClusterFile) ReadClusterLinks(nicknameID uint32) ([]clusterLink, error) {
+	clusterID, err := m.readClusterID(nicknameID)
+	if err != nil {
+		return nil, err
+	}
+
+	linksOffsetsOffset := m.linksOffsetsOffset()
+	b := make([]byte, 16)
+	if _, err := m.file.ReadAt(b, linksOffsetsOffset+int64(clusterID)*8); err != nil {
+		return nil, err
+	}
+	first := binary.LittleEndian.Uint64(b[:8])
+	last := binary.LittleEndian.Uint64(b[8:16])
+	if last < first {
+		return nil, ErrCorrupted
+	}
+
+	linksOffset := linksOffsetsOffset + int64(m.clusterCount+1)*8
+	data := make([]byte, last-first)
+	if _, err := m.file.ReadAt(data, linksOffset+int64(first)); err != nil {
+		return nil, err
+	}
+	links := make([]clusterLink, 0)
+	for len(data) != 0 {
+		link, n, err := parseClusterLink(data)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+		data = data[n:]
+	}
+	return links, nil
+}
+
+// A value shared by 2 to maxShared nicknames, an edge of the graph.
+type clusterLink struct {
+	kind        uint8
+	value       string
+	nicknameIDs []uint32 // Sorted.
+}
+
+func encodeClusterLink(link clusterLink) []byte {
+	b := make([]byte, 1+1+len(link.value)+4+4*len(link.nicknameIDs))
+	b[0] = link.kind
+	b[1] = uint8(len(link.value))
+	n := 2 + copy(b[2:], link.value)
+	binary.LittleEndian.PutUint32(b[n:], uint32(len(link.nicknameIDs)))
+	n += 4
+	for _, nicknameID := range link.nicknameIDs {
+		binary.LittleEndian.PutUint32(b[n:], nicknameID)
+		n += 4
+	}
+	return b
+}
+
+// Returns the link and its size.
+func parseClusterLink(b []byte) (clusterLink, int, error) {
+	if len(b) < 2 || len(b) < 2+int(b[1])+4 {
+		return clusterLink{}, 0, ErrCorrupted
+	}
+	link := clusterLink{kind: b[0], value: string(b[2 : 2+int(b[1])])}
+	n := 2 + int(b[1])
+	count := int(binary.LittleEndian.Uint32(b[n:]))
+	n += 4
+	if count > (len(b)-n)/4 {
+		return clusterLink{}, 0, ErrCorrupted
+	}
+	link.nicknameIDs = make([]uint32, count)
+	for i := range link.nicknameIDs {
+		link.nicknameIDs[i] = binary.LittleEndian.Uint32(b[n:])
+		n += 4
+	}
+	return link, n, nil
+}
+
+type unionFind struct {
+	parent []uint32
+	size   []uint32
+}
+
+func newUnionFind(n int) *unionFind {
+	m := &unionFind{parent: make([]uint32, n), size: make([]uint32, n)}
+	for i := range m.parent {
+		m.parent[i] = uint32(i)
+		m.size[i] = 1
+	}
+	return m
+}
+
+func (m *unionFind) find(x uint32) uint32 {
+	for m.parent[x] != x {
+		m.parent[x] = m.parent[m.parent[x]]
+		x = m.parent[x]
+	}
+	return x
+}
+
+func (m *unionFind) union(a, b uint32) {
+	a, b = m.find(a), m.find(b)
+	if a == b {
+		return
+	}
+	if m.size[a] < m.size[b] {
+		a, b = b, a
+	}
+	m.parent[b] = a
+	m.size[a] += m.size[b]
+}
+
+// Treats nicknames as nodes and shared IP addresses and fingerprints as edges,
+// and writes connected components of the graph with the edges linking them to the cluster file.
+// Keys shared by more than maxShared nicknames are ignored.
+func BuildClusters(from *MordorLogsDB, clusterFile string, maxShared int) error {
+	if from.ipIndex == nil || from.fingerprintIndex == nil {
+		return ErrIndexNotFound
+	}
+
+	nicknameCount := from.GetEntryCount()
+	ipLinks, err := collectIPLinks(from.ipIndex, nicknameCount, maxShared)
+	if err != nil {
+		return err
+	}
+	fingerprintLinks, err := collectFingerprintLinks(from, nicknameCount, maxShared)
+	if err != nil {
+		return err
+	}
+	links := append(ipLinks, fingerprintLinks...)
+
+	uf := newUnionFind(nicknameCount)
+	for _, link := range links {
+		for _, nicknameID := range link.nicknameIDs[1:] {
+			uf.union(link.nicknameIDs[0], nicknameID)
+		}
+	}
+
+	// Clusters are numbered in the order of their first member.
+	clusterIDs := make([]uint32, nicknameCount)
+	rootClusterIDs := make(map[uint32]uint32)
+	clusterSizes := make([]uint32, 0)
+	for i := range clusterIDs {
+		root := uf.find(uint32(i))
+		if uf.size[root] < 2 {
+			clusterIDs[i] = noClusterID
+			continue
+		}
+		clusterID, ok := rootClusterIDs[root]
+		if !ok {
+			clusterID = uint32(len(clusterSizes))
+			rootClusterIDs[root] = clusterID
+			clusterSizes = append(clusterSizes, 0)
+		}
+		clusterIDs[i] = clusterID
+		clusterSizes[clusterID]++
+	}
+
+	membersOffsets := make([]uint32, len(clusterSizes)+1)
+	for i, size := range clusterSizes {
+		membersOffsets[i+1] = membersOffsets[i] + size
+	}
+	members := make([]uint32, membersOffsets[len(clusterSizes)])
+	next := append([]uint32(nil), membersOffsets[:len(clusterSizes)]...)
+	for i, clusterID := range clusterIDs {
+		if clusterID != noClusterID {
+			members[next[clusterID]] = uint32(i)
+			next[clusterID]++
+		}
+	}
+
+	clusterLinks := make([][]clusterLink, len(clusterSizes))
+	for _, link := range links {
+		clusterID := clusterIDs[link.nicknameIDs[0]]
+		clusterLinks[clusterID] = append(clusterLinks[clusterID], link)
+	}
+	for _, links := range clusterLinks {
+		sortClusterLinks(links)
+	}
+
+	return writeClusterFile(clusterFile, clusterIDs, membersOffsets, members, clusterLinks)
+}
+
+// Links with more nicknames first.
+func sortClusterLinks(links []clusterLink) {
+	sort.Slice(links, func(i, j int) bool {
+		if len(links[i].nicknameIDs) != len(links[j].nicknameIDs) {
+			return len(links[i].nicknameIDs) > len(links[j].nicknameIDs)
+		}
+		if links[i].kind != links[j].kind {
+			return links[i].kind < links[j].kind
+		}
+		return links[i].value < links[j].value
+	})
+}
+
+type keyGroupEntry struct {
+	nicknameID uint32
+	dataOffset uint64
+}
+
+// Calls fn for every key of the index shared by 2 to maxShared nicknames, with the entrys of the key.
+func forEachSharedKey(index *KeyIndexFile, nicknameCount int, maxShared int, fn func(key []byte, group []keyGroupEntry) error) error {
+	var groupKey []byte
+	group := make([]keyGroupEntry, 0)
+	nicknames := 0
+	flushGroup := func() error {
+		if nicknames < 2 || nicknames > maxShared {
+			return nil
+		}
+		return fn(groupKey, group)
+	}
+
+	it := index.Iterator()
+	for {
+		key, nicknameID, dataOffset, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return err
+		}
+		if int(nicknameID) >= nicknameCount {
+			return ErrCorrupted
+		}
+
+		if !bytes.Equal(key, groupKey) {
+			if err := flushGroup(); err != nil {
+				return err
+			}
+			groupKey = append(groupKey[:0], key...)
+			group = group[:0]
+			nicknames = 0
+		}
+		// Entrys with equal keys are sorted by nickname ID.
+		if len(group) == 0 || group[len(group)-1].nicknameID != nicknameID {
+			nicknames++
+		}
+		// The entrys of a key shared by too many nicknames are not needed.
+		if nicknames <= maxShared {
+			group = append(group, keyGroupEntry{nicknameID, dataOffset})
+		}
+	}
+	return flushGroup()
+}
+
+func uniqueNickNameIDs(group []keyGroupEntry) []uint32 {
+	nicknameIDs := make([]uint32, 0, len(group))
+	for _, entry := range group {
+		if len(nicknameIDs) == 0 || nicknameIDs[len(nicknameIDs)-1] != entry.nicknameID {
+			nicknameIDs = append(nicknameIDs, entry.nicknameID)
+		}
+	}
+	return nicknameIDs
+}
+
+func collectIPLinks(index *KeyIndexFile, nicknameCount int, maxShared int) ([]clusterLink, error) {
+	links := make([]clusterLink, 0)
+	err := forEachSharedKey(index, nicknameCount, maxShared, func(key []byte, group []keyGroupEntry) error {
+		links = append(links, clusterLink{kind: clusterLinkIP, value: net.IP(key).String(), nicknameIDs: uniqueNickNameIDs(group)})
+		return nil
+	})
+	return links, err
+}
+
+// The fingerprint index keeps only hashes, so the nicknames of a hash are split by their full fingerprints.
+// A hash shared by more than maxShared nicknames is skipped without reading the data,
+// a collision there can only hide a link, never add one.
+func collectFingerprintLinks(from *MordorLogsDB, nicknameCount int, maxShared int) ([]clusterLink, error) {
+	links := make([]clusterLink, 0)
+	err := forEachSharedKey(from.fingerprintIndex, nicknameCount, maxShared, func(key []byte, group []keyGroupEntry) error {
+		fingerprintNickNameIDs := make(map[string][]uint32)
+		for _, entry := range group {
+			data, err := from.data.ReadEntryAt(entry.dataOffset)
+			if err != nil {
+				return err
+			}
+			nicknameIDs := fingerprintNickNameIDs[data.Fingerprint]
+			if len(nicknameIDs) == 0 || nicknameIDs[len(nicknameIDs)-1] != entry.nicknameID {
+				fingerprintNickNameIDs[data.Fingerprint] = append(nicknameIDs, entry.nicknameID)
+			}
+		}
+		for fingerprint, nicknameIDs := range fingerprintNickNameIDs {
+			if len(nicknameIDs) >= 2 {
+				links = append(links, clusterLink{kind: clusterLinkFingerprint, value: fingerprint, nicknameIDs: nicknameIDs})
+			}
+		}
+		return nil
+	})
+	return links, err
+}
+
+func writeClusterFile(filePath string, clusterIDs []uint32, membersOffsets []uint32, members []uint32, clusterLinks [][]clusterLink) error {
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := NewFileHeader(clusterHeaderMarker).writeHeaderToFile(file); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	b := make([]byte, 8)
+	writeUint32 := func(v uint32) error {
+		binary.LittleEndian.PutUint32(b, v)
+		_, err := w.Write(b[:4])
+		return err
+	}
+
+	if err := writeUint32(uint32(len(clusterIDs))); err != nil {
+		return err
+	}
+	if err := writeUint32(uint32(len(membersOffsets) - 1)); err != nil {
+		return err
+	}
+	for _, values := range [][]uint32{clusterIDs, membersOffsets, members} {
+		for _, v := range values {
+			if err := writeUint32(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	encodedLinks := make([][]byte, 0)
+	linksOffset := uint64(0)
+	for _, links := range clusterLinks {
+		binary.LittleEndian.PutUint64(b, linksOffset)
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		for _, link := range links {
+			encoded := encodeClusterLink(link)
+			encodedLinks = append(encodedLinks, encoded)
+			linksOffset += uint64(len(encoded))
+		}
+	}
+	binary.LittleEndian.PutUint64(b, linksOffset)
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	for _, encoded := range encodedLinks {
+		if _, err := w.Write(encoded); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// A value shared by several cluster members.
+type ClusterLink struct {
+	Kind      string // "IP" or "Fingerprint"
+	Value     string
+	NickNames []string
+}
+
+// Returns all suspected alt accounts of the nickname (including itself) and the values linking them.
+func (m *MordorLogsDB) FindCluster(nickname string) ([]string, []ClusterLink, error) {
+	if m.clusters == nil {
+		return nil, nil, ErrIndexNotFound
+	}
+	nicknameID, err := m.firstIndex.FindNickNameID(nickname)
+	if err != nil {
+		return nil, nil, err
+	}
+	nicknameIDs, err := m.clusters.ReadCluster(nicknameID)
+	if err != nil {
+		return nil, nil, err
+	}
+	links, err := m.clusters.ReadClusterLinks(nicknameID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nicknames := make(map[uint32]string, len(nicknameIDs))
+	members := make([]string, len(nicknameIDs))
+	for i, id := range nicknameIDs {
+		if members[i], err = m.GetNickNameByID(id); err != nil {
+			return nil, nil, err
+		}
+		nicknames[id] = members[i]
+	}
+
+	clusterLinks := make([]ClusterLink, len(links))
+	for i, link := range links {
+		clusterLinks[i] = ClusterLink{Kind: "IP", Value: link.value, NickNames: make([]string, len(link.nicknameIDs))}
+		if link.kind == clusterLinkFingerprint {
+			clusterLinks[i].Kind = "Fingerprint"
+		}
+		for j, id := range link.nicknameIDs {
+			linked, ok := nicknames[id]
+			if !ok {
+				return nil, nil, ErrCorrupted
+			}
+			clusterLinks[i].NickNames[j] = linked
+		}
+	}
+	return members, clusterLinks, nil
+}
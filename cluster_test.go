@@ -0,0 +1,131 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClusterLinkEncoding(t *testing.T) {
+	tests := []clusterLink{
+		{clusterLinkIP, "192.168.1.1", []uint32{0, 1}},
+		{clusterLinkFingerprint, "fp/dev4", []uint32{3, 7, 1 << 31}},
+		{clusterLinkFingerprint, strings.Repeat("f", 255), []uint32{}},
+		{clusterLinkIP, "", []uint32{5}},
+	}
+	for _, link := range tests {
+		b := encodeClusterLink(link)
+		got, n, err := parseClusterLink(append(b, 0xff))
+		if err != nil {
+			t.Errorf("%q: %v", link.value, err)
+			continue
+		}
+		if n != len(b) || !reflect.DeepEqual(got, link) {
+			t.Errorf("%q: parsed %v (%d bytes), want %v (%d bytes)", link.value, got, n, link, len(b))
+		}
+		for i := 0; i < len(b); i++ {
+			if _, _, err := parseClusterLink(b[:i]); err != ErrCorrupted {
+				t.Errorf("%q: %d of %d bytes parsed with %v", link.value, i, len(b), err)
+				break
+			}
+		}
+	}
+}
+
+func TestFindCluster(t *testing.T) {
+	mldb, remove := openTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	tests := []struct {
+		nickname string
+		members  []string
+		links    []ClusterLink
+		err      error
+	}{
+		{"Mike_Tyson", []string{"Alice", "Mike_Tyson"}, []ClusterLink{
+			{"Fingerprint", "fp/dev4", []string{"Alice", "Mike_Tyson"}},
+		}, nil},
+		{"Alice", []string{"Alice", "Mike_Tyson"}, []ClusterLink{
+			{"Fingerprint", "fp/dev4", []string{"Alice", "Mike_Tyson"}},
+		}, nil},
+		{"[ABC]_Carl", []string{"[ABC]_Bob", "[ABC]_Carl"}, []ClusterLink{
+			{"IP", "192.168.1.1", []string{"[ABC]_Bob", "[ABC]_Carl"}},
+		}, nil},
+		{"John_Smith", nil, nil, ErrNoCluster},
+		{"Nobody", nil, nil, ErrEntryNotFound},
+	}
+	for _, test := range tests {
+		members, links, err := mldb.FindCluster(test.nickname)
+		if err != test.err {
+			t.Errorf("%s: error %v, want %v", test.nickname, err, test.err)
+			continue
+		}
+		if !reflect.DeepEqual(members, test.members) || !reflect.DeepEqual(links, test.links) {
+			t.Errorf("%s: got %v %v, want %v %v", test.nickname, members, links, test.members, test.links)
+		}
+	}
+}
+
+func TestBuildClustersMaxShared(t *testing.T) {
+	mldb, remove := openTestDatabase(t, sampleTestLogs)
+	defer remove()
+	dirPath, err := ioutil.TempDir("", "mordorlogs-test-cluster-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	tests := []struct {
+		maxShared int
+		nickname  string
+		members   int
+	}{
+		{1, "Mike_Tyson", 0},
+		{1, "[ABC]_Bob", 0},
+		{2, "Mike_Tyson", 2},
+		{2, "[ABC]_Bob", 2},
+	}
+	for i, test := range tests {
+		clusterPath := filepath.Join(dirPath, fmt.Sprintf("cluster%d.bin", i))
+		if err := BuildClusters(mldb, clusterPath, test.maxShared); err != nil {
+			t.Fatal(err)
+		}
+		var clusters ClusterFile
+		if err := clusters.Open(clusterPath); err != nil {
+			t.Fatal(err)
+		}
+		nicknameID, err := mldb.firstIndex.FindNickNameID(test.nickname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members, err := clusters.ReadCluster(nicknameID)
+		if test.members == 0 && err != ErrNoCluster {
+			t.Errorf("maxShared %d, %s: got %v, %v, want ErrNoCluster", test.maxShared, test.nickname, members, err)
+		} else if test.members != 0 && len(members) != test.members {
+			t.Errorf("maxShared %d, %s: got %v, %v, want %d members", test.maxShared, test.nickname, members, err, test.members)
+		}
+		clusters.Close()
+	}
+}
@@ -0,0 +1,301 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+const commandsUsage = `Usage: mordorlogs [command] [flags]
+
+Commands:
+  bot     Run the Telegram bot (default when no command is given).
+  build   Convert raw logs into a sorted database.
+  append  Merge new log days into an existing database.
+  cluster Show suspected alt accounts of a nickname.
+  time    List everyone who connected during a time range, optionally on one server.
+  query   List entrys matching a filter expression.
+  verify  Check the checksums and offsets of a database.
+  reindex Rebuild the first and second index from the data file.
+  migrate Rewrite a database of an older version into a new directory.
+
+Run "mordorlogs <command> -h" for the command flags.
+`
+
+var errUsage = errors.New("invalid usage")
+
+func runCommand(name string, args []string) error {
+	switch name {
+	case "bot":
+		return botCommand(args)
+	case "build":
+		return buildCommand(args)
+	case "append":
+		return appendCommand(args)
+	case "cluster":
+		return clusterCommand(args)
+	case "time":
+		return timeCommand(args)
+	case "query":
+		return queryCommand(args)
+	case "verify":
+		return verifyCommand(args)
+	case "reindex":
+		return reindexCommand(args)
+	case "migrate":
+		return migrateCommand(args)
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stderr, commandsUsage)
+		return nil
+	}
+	fmt.Fprint(os.Stderr, commandsUsage)
+	return fmt.Errorf("unknown command %q", name)
+}
+
+func parseCommandFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+func addBuildFlags(flags *flag.FlagSet) func() BuildOptions {
+	memoryLimit := flags.Int("memory", DefaultMemoryLimit/(1024*1024), "memory limit of the sorting stages in MiB")
+	clusterMaxShared := flags.Int("cluster-max-shared", DefaultClusterMaxShared, "ignore IP addresses and fingerprints shared by more nicknames when clustering")
+	workers := flags.Int("workers", runtime.NumCPU(), "number of goroutines parsing log files")
+	return func() BuildOptions {
+		return BuildOptions{MemoryLimit: *memoryLimit * 1024 * 1024, ClusterMaxShared: *clusterMaxShared, Workers: *workers}
+	}
+}
+
+func botCommand(args []string) error {
+	flags := flag.NewFlagSet("bot", flag.ContinueOnError)
+	dbPath := flags.String("db", "./mordor.db", "database directory")
+	mmap := flags.Bool("mmap", true, "map the database files into memory")
+	workers := flags.Int("workers", DefaultBotWorkers, "number of messages handled at the same time")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+	runBot(*dbPath, *mmap, *workers)
+	return nil
+}
+
+func buildCommand(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	logsPath := flags.String("logs", "", "directory containing client_log/<date> folders")
+	outPath := flags.String("out", "./mordor.db", "database directory to create or replace")
+	options := addBuildFlags(flags)
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+	if *logsPath == "" {
+		flags.Usage()
+		return errUsage
+	}
+	return BuildDatabase(*logsPath, *outPath, options())
+}
+
+func appendCommand(args []string) error {
+	flags := flag.NewFlagSet("append", flag.ContinueOnError)
+	logsPath := flags.String("logs", "", "directory containing only the new client_log/<date> folders")
+	dbPath := flags.String("db", "./mordor.db", "existing database directory")
+	options := addBuildFlags(flags)
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+	if *logsPath == "" {
+		flags.Usage()
+		return errUsage
+	}
+	return AppendLogsToDatabase(*logsPath, *dbPath, options())
+}
+
+func reindexCommand(args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	dbPath := flags.String("db", "./mordor.db", "database directory with data.bin")
+	memoryLimit := flags.Int("memory", DefaultMemoryLimit/(1024*1024), "memory limit of the sorting in MiB")
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+	return ReindexDatabase(*dbPath, BuildOptions{MemoryLimit: *memoryLimit * 1024 * 1024})
+}
+
+func migrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dbPath := flags.String("db", "./mordor.db", "database directory of an older version")
+	outPath := flags.String("out", "", "new database directory, must not exist")
+	options := addBuildFlags(flags)
+	if err := parseCommandFlags(flags, args); err != nil {
+		return err
+	}
+	if *outPath == "" {
+		flags.Usage()
+		return errUsage
+	}
+	return MigrateDatabase(*dbPath, *outPath, options())
+}
+
+func clusterCommand(args []string) error {
+	flags := flag.NewFlagSet("cluster", flag.ContinueOnError)
+	dbPath := flags.String("db", "./mordor.db", "database directory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: mordorlogs cluster [flags] <nickname>")
+		flags.PrintDefaults()
+		return errUsage
+	}
+
+	mldb, err := OpenReadOnly(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer mldb.Close()
+
+	nicknames, links, err := mldb.FindCluster(flags.Arg(0))
+	if err == ErrNoCluster {
+		fmt.Println("No suspected alt accounts found.")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Printf("Cluster of %d nicknames:\n", len(nicknames))
+	for _, nickname := range nicknames {
+		fmt.Println(" ", nickname)
+	}
+	fmt.Println("Linked by:")
+	for _, link := range links {
+		fmt.Printf("  %s %s: %v\n", link.Kind, link.Value, link.NickNames)
+	}
+	return nil
+}
+
+func timeCommand(args []string) error {
+	flags := flag.NewFlagSet("time", flag.ContinueOnError)
+	dbPath := flags.String("db", "./mordor.db", "database directory")
+	server := flags.String("server", "", "only entrys from the game server (ip:port)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, `Usage: mordorlogs time [flags] "01.08.2020 21:00-21:30"`)
+		flags.PrintDefaults()
+		return errUsage
+	}
+	from, to, err := parseTimeRange(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	mldb, err := OpenReadOnly(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer mldb.Close()
+
+	var it *KeyRangeIterator
+	if *server != "" {
+		it, err = mldb.FindByServer(*server, from, to)
+	} else {
+		it, err = mldb.FindByTimeRange(from, to)
+	}
+	if err != nil {
+		return err
+	}
+	return printEntrys(it)
+}
+
+func queryCommand(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	dbPath := flags.String("db", "./mordor.db", "database directory")
+	explain := flags.Bool("explain", false, "print how the entrys are found")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, `Usage: mordorlogs query [flags] "ip=1.2.3.0/24 AND brand=Xiaomi AND time>=01.08.2020"`)
+		flags.PrintDefaults()
+		return errUsage
+	}
+	q, err := ParseQuery(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	mldb, err := OpenReadOnly(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer mldb.Close()
+
+	it, err := mldb.RunQuery(q)
+	if err != nil {
+		return err
+	}
+	if *explain {
+		fmt.Fprintln(os.Stderr, "Plan:", it.GetPlan())
+	}
+	return printEntrys(it)
+}
+
+func verifyCommand(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: mordorlogs verify <database directory>")
+		return errUsage
+	}
+
+	result, err := VerifyDatabase(flags.Arg(0), func(c Corruption) {
+		fmt.Println(c.String())
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Checked %d nicknames and %d data entrys.\n", result.NickNames, result.DataEntrys)
+	if result.Corruptions != 0 {
+		return fmt.Errorf("found %d corruptions", result.Corruptions)
+	}
+	fmt.Println("No corruptions found.")
+	return nil
+}
+
+func printEntrys(it EntryIterator) error {
+	for {
+		nickname, data, err := it.Next()
+		if err == ErrIterationDone {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fmt.Println(data.Time.UTC().Format(timeFormatLayout), nickname, data.IP, data.Server)
+	}
+}
@@ -19,16 +19,25 @@
 package main
 
 import (
+	"bufio"
 	"encoding/binary"
+	"hash/crc32"
+	"io"
 	"net"
 	"os"
 	"time"
 )
 
 /* Data file: Contains information about player.
+Every entry also keeps the nickname it belongs to, so the indexes can be rebuilt from this file alone.
 ===============================DataEntry==============================
+	RecordLength		= 2 byte // Size of the rest of the entry, so it can be read at once.
+	Checksum			= 4 byte // CRC32C of the rest of the entry after Checksum.
+	NickNameLength		= 2 byte
+	NickName			= NickNameLength byte
 	Time				= 8 byte
-	IP					= 4 byte
+	IPLength			= 1 byte // 4 for IPv4, 16 for IPv6.
+	IP					= IPLength byte
 	AndroidLength		= 1 byte
 	Android				= AndroidLength byte
 	BrandLength			= 1 byte
@@ -53,8 +62,8 @@ type DataEntry struct {
 }
 
 func (m *DataEntry) Validate() error {
-	if len(m.IP.To4()) > 4 {
-		return ErrLongIP
+	if dataEntryIP(m.IP) == nil {
+		return ErrInvalidIP
 	}
 	if len(m.Android) > 255 || len(m.Brand) > 255 || len(m.Model) > 255 || len(m.Fingerprint) > 255 || len(m.Server) > 255 {
 		return ErrLongStr
@@ -62,11 +71,41 @@ func (m *DataEntry) Validate() error {
 	return nil
 }
 
+func (m *DataEntry) Equal(entry *DataEntry) bool {
+	return m.Time.Equal(entry.Time) && m.IP.Equal(entry.IP) && m.Android == entry.Android && m.Brand == entry.Brand &&
+		m.Model == entry.Model && m.Fingerprint == entry.Fingerprint && m.Server == entry.Server
+}
+
+// Returns the address as it is stored: 4 bytes for IPv4, including IPv4-mapped IPv6 addresses, and 16 bytes for IPv6.
+func dataEntryIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	if len(ip) == net.IPv6len {
+		return ip
+	}
+	return nil
+}
+
+// Keeps entrys from the game server, ids are numbers of the kept entrys in the original slice.
+func FilterDataByServer(entrys []*DataEntry, server string) (filtered []*DataEntry, ids []int) {
+	for i, data := range entrys {
+		if data.Server == server {
+			filtered = append(filtered, data)
+			ids = append(ids, i)
+		}
+	}
+	return filtered, ids
+}
+
 var dataHeaderMarker = [16]byte{'M', 'o', 'r', 'd', 'o', 'r', 'L', 'o', 'g', 's', 'D', 'B', 0x03, 0x03, 0x03, 0x03}
 
 type DataFile struct {
 	file        *os.File
 	writeOffset int64
+	mapped      []byte // Set by Map, reads are served from it instead of the file.
+	bulk        *bulkWriter
+	readOnly    bool
 }
 
 func (m *DataFile) Open(filePath string) (isnew bool, err error) {
@@ -94,14 +133,52 @@ func (m *DataFile) Open(filePath string) (isnew bool, err error) {
 	return false, err
 }
 
+// Opens an existing file for reading only, writes return ErrReadOnly.
+func (m *DataFile) OpenReadOnly(filePath string) (err error) {
+	if m.file, m.writeOffset, err = openFileReadOnly(filePath, dataHeaderMarker); err != nil {
+		return err
+	}
+	m.readOnly = true
+	return nil
+}
+
 func (m *DataFile) Close() error {
+	if err := flushBulkWriter(m.bulk); err != nil {
+		m.file.Close()
+		return err
+	}
+	if m.mapped != nil {
+		if err := unmapFile(m.mapped); err != nil {
+			return err
+		}
+		m.mapped = nil
+	}
 	return m.file.Close()
 }
 
+// Maps the file into memory. Nothing can be written to a mapped file.
+func (m *DataFile) Map() (err error) {
+	m.mapped, err = mapFile(m.file, m.writeOffset)
+	m.readOnly = true
+	return err
+}
+
 func (m *DataFile) Sync() error {
+	if err := m.Flush(); err != nil {
+		return err
+	}
 	return m.file.Sync()
 }
 
+// Buffers written entrys in memory, up to bufferSize bytes. They can't be read before Flush.
+func (m *DataFile) EnableBulkWrite(bufferSize int) {
+	m.bulk = newBulkWriter(m.file, bufferSize)
+}
+
+func (m *DataFile) Flush() error {
+	return flushBulkWriter(m.bulk)
+}
+
 func (m *DataFile) readHeader() error {
 	fh := NewFileHeader(dataHeaderMarker)
 	if err := fh.readHeaderFromFile(m.file); err != nil {
@@ -121,132 +198,135 @@ func (m *DataFile) writeHeader() error {
 	return nil
 }
 
-func (m *DataFile) writeString8(str string) error {
-	length := []byte{0}
-	length[0] = uint8(len(str))
-	if _, err := m.file.WriteAt(length, m.writeOffset); err != nil {
-		return err
-	}
-	m.writeOffset++
-	strBytes := []byte(str)
-	if _, err := m.file.WriteAt(strBytes, m.writeOffset); err != nil {
-		return err
+func (m *DataFile) WriteEntry(nickname string, entry DataEntry) (uint64, error) {
+	if m.readOnly {
+		return 0, ErrReadOnly
 	}
-	m.writeOffset += int64(length[0])
-	return nil
-}
-
-func (m *DataFile) WriteEntry(entry DataEntry) (uint64, error) {
-	if err := entry.Validate(); err != nil {
+	record, err := encodeDataEntry(nickname, &entry)
+	if err != nil {
 		return 0, err
 	}
 	returnOffset := uint64(m.writeOffset - fileHeaderSize)
 
-	b := make([]byte, 8)
-	binary.LittleEndian.PutUint64(b, uint64(entry.Time.Unix()))
-	if _, err := m.file.WriteAt(b, m.writeOffset); err != nil {
+	if err := writeAt(m.file, m.bulk, record, m.writeOffset); err != nil {
 		return 0, err
 	}
-	m.writeOffset += 8
+	m.writeOffset += int64(len(record))
 
-	if _, err := m.file.WriteAt(entry.IP.To4(), m.writeOffset); err != nil {
-		return 0, err
-	}
-	m.writeOffset += 4
+	return returnOffset, nil
+}
 
-	if err := m.writeString8(entry.Android); err != nil {
-		return 0, err
+// Returns the whole record including RecordLength.
+func encodeDataEntry(nickname string, entry *DataEntry) ([]byte, error) {
+	if len(nickname) > MaxNickNameLength {
+		return nil, ErrLongNickName
 	}
-	if err := m.writeString8(entry.Brand); err != nil {
-		return 0, err
-	}
-	if err := m.writeString8(entry.Model); err != nil {
-		return 0, err
-	}
-	if err := m.writeString8(entry.Fingerprint); err != nil {
-		return 0, err
+	if err := entry.Validate(); err != nil {
+		return nil, err
 	}
-	if err := m.writeString8(entry.Server); err != nil {
-		return 0, err
+	ip := dataEntryIP(entry.IP)
+	strs := []string{entry.Android, entry.Brand, entry.Model, entry.Fingerprint, entry.Server}
+	length := 4 + 2 + len(nickname) + 8 + 1 + len(ip)
+	for _, str := range strs {
+		length += 1 + len(str)
 	}
 
-	return returnOffset, nil
+	record := make([]byte, 2+length)
+	binary.LittleEndian.PutUint16(record[:2], uint16(length))
+	binary.LittleEndian.PutUint16(record[6:8], uint16(len(nickname)))
+	b := record[8:]
+	copy(b, nickname)
+	b = b[len(nickname):]
+	binary.LittleEndian.PutUint64(b[:8], uint64(entry.Time.Unix()))
+	b[8] = uint8(len(ip))
+	copy(b[9:], ip)
+	b = b[9+len(ip):]
+	for _, str := range strs {
+		b[0] = uint8(len(str))
+		copy(b[1:], str)
+		b = b[1+len(str):]
+	}
+	binary.LittleEndian.PutUint32(record[2:6], crc32.Checksum(record[6:], crc32cTable))
+	return record, nil
 }
 
-func (m *DataFile) ReadEntryAt(off uint64) (*DataEntry, error) {
-	offset := int64(off) + fileHeaderSize
+// Most records are shorter, so they are read with a single call.
+const dataEntryReadSize = 256
 
-	b := make([]byte, 8)
-	if _, err := m.file.ReadAt(b, offset); err != nil {
-		return nil, err
-	}
-
-	offset += 8
-	entry := new(DataEntry)
-	entry.Time = time.Unix(int64(binary.LittleEndian.Uint64(b)), 0)
+func (m *DataFile) ReadEntryAt(off uint64) (*DataEntry, error) {
+	_, entry, err := m.ReadEntryWithNickNameAt(off)
+	return entry, err
+}
 
-	bip := make([]byte, 4)
-	if _, err := m.file.ReadAt(bip, offset); err != nil {
-		return nil, err
+// The same as ReadEntryAt, but also returns the nickname the entry belongs to.
+func (m *DataFile) ReadEntryWithNickNameAt(off uint64) (string, *DataEntry, error) {
+	offset := int64(off) + fileHeaderSize
+	if m.mapped != nil {
+		if offset < fileHeaderSize || offset+2 > int64(len(m.mapped)) {
+			return "", nil, io.EOF
+		}
+		length := int64(binary.LittleEndian.Uint16(m.mapped[offset:]))
+		if offset+2+length > int64(len(m.mapped)) {
+			return "", nil, ErrCorrupted
+		}
+		return decodeDataEntry(m.mapped[offset+2 : offset+2+length])
 	}
-	offset += 4
-	entry.IP = net.IP(bip)
 
-	length := make([]byte, 1)
-	if _, err := m.file.ReadAt(length, offset); err != nil {
-		return nil, err
+	b := make([]byte, dataEntryReadSize)
+	n, err := m.file.ReadAt(b, offset)
+	if n < 2 {
+		return "", nil, err
+	} else if err != nil && err != io.EOF {
+		return "", nil, err
 	}
-	offset++
-	android := make([]byte, length[0])
-	if _, err := m.file.ReadAt(android, offset); err != nil {
-		return nil, err
+	length := int(binary.LittleEndian.Uint16(b))
+	if 2+length > n {
+		// Long record or the end of the file.
+		b = make([]byte, 2+length)
+		if _, err := m.file.ReadAt(b, offset); err == io.EOF {
+			return "", nil, ErrCorrupted
+		} else if err != nil {
+			return "", nil, err
+		}
 	}
-	offset += int64(length[0])
-	entry.Android = string(android)
+	return decodeDataEntry(b[2 : 2+length])
+}
 
-	if _, err := m.file.ReadAt(length, offset); err != nil {
-		return nil, err
-	}
-	offset++
-	brand := make([]byte, length[0])
-	if _, err := m.file.ReadAt(brand, offset); err != nil {
-		return nil, err
+// Decodes the entry without RecordLength, b must contain exactly one entry.
+func decodeDataEntry(b []byte) (string, *DataEntry, error) {
+	if len(b) < 4+2 || len(b) < 4+2+int(binary.LittleEndian.Uint16(b[4:6]))+8+1 {
+		return "", nil, ErrCorrupted
 	}
-	offset += int64(length[0])
-	entry.Brand = string(brand)
-
-	if _, err := m.file.ReadAt(length, offset); err != nil {
-		return nil, err
+	if binary.LittleEndian.Uint32(b[:4]) != crc32.Checksum(b[4:], crc32cTable) {
+		return "", nil, ErrChecksumMismatch
 	}
-	offset++
-	model := make([]byte, length[0])
-	if _, err := m.file.ReadAt(model, offset); err != nil {
-		return nil, err
+	nicknameLength := int(binary.LittleEndian.Uint16(b[4:6]))
+	nickname := string(b[6 : 6+nicknameLength])
+	b = b[6+nicknameLength:]
+	entry := new(DataEntry)
+	entry.Time = time.Unix(int64(binary.LittleEndian.Uint64(b[:8])), 0)
+	ipLength := int(b[8])
+	if ipLength != net.IPv4len && ipLength != net.IPv6len || len(b) < 9+ipLength {
+		return "", nil, ErrCorrupted
 	}
-	offset += int64(length[0])
-	entry.Model = string(model)
+	entry.IP = net.IP(append([]byte(nil), b[9:9+ipLength]...))
+	b = b[9+ipLength:]
 
-	if _, err := m.file.ReadAt(length, offset); err != nil {
-		return nil, err
+	for _, str := range []*string{&entry.Android, &entry.Brand, &entry.Model, &entry.Fingerprint, &entry.Server} {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return "", nil, ErrCorrupted
+		}
+		*str = string(b[1 : 1+int(b[0])])
+		b = b[1+int(b[0]):]
 	}
-	offset++
-	fingerprint := make([]byte, length[0])
-	if _, err := m.file.ReadAt(fingerprint, offset); err != nil {
-		return nil, err
+	if len(b) != 0 {
+		return "", nil, ErrCorrupted
 	}
-	offset += int64(length[0])
-	entry.Fingerprint = string(fingerprint)
 
-	if _, err := m.file.ReadAt(length, offset); err != nil {
-		return nil, err
-	}
-	offset++
-	server := make([]byte, length[0])
-	if _, err := m.file.ReadAt(server, offset); err != nil {
-		return nil, err
-	}
-	// offset += int64(length[0])
-	entry.Server = string(server)
+	return nickname, entry, nil
+}
 
-	return entry, nil
+func (m *DataFile) Iterator() *DataFileIterator {
+	size := m.writeOffset - fileHeaderSize
+	return &DataFileIterator{reader: bufio.NewReaderSize(io.NewSectionReader(m.file, fileHeaderSize, size), dataFileIteratorBufferSize)}
 }
@@ -0,0 +1,68 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const dataFileIteratorBufferSize = 1024 * 1024
+
+// Reads data entrys in the order they are stored, without the indexes.
+type DataFileIterator struct {
+	reader *bufio.Reader
+	offset uint64
+	record []byte
+}
+
+// Returns the offset of the entry, its nickname and the entry.
+func (m *DataFileIterator) Next() (uint64, string, *DataEntry, error) {
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(m.reader, length); err == io.EOF {
+		return 0, "", nil, ErrIterationDone
+	} else if err != nil {
+		return 0, "", nil, m.entryError(err)
+	}
+	n := 2 + int(binary.LittleEndian.Uint16(length))
+	if cap(m.record) < n {
+		m.record = make([]byte, n)
+	}
+	m.record = m.record[:n]
+	if _, err := io.ReadFull(m.reader, m.record[2:]); err != nil {
+		return 0, "", nil, m.entryError(err)
+	}
+
+	nickname, entry, err := decodeDataEntry(m.record[2:])
+	if err != nil {
+		return 0, "", nil, m.entryError(err)
+	}
+	offset := m.offset
+	m.offset += uint64(n)
+	return offset, nickname, entry, nil
+}
+
+func (m *DataFileIterator) entryError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrCorrupted
+	}
+	return fmt.Errorf("data entry at offset %d: %w", m.offset, err)
+}
@@ -21,6 +21,9 @@ package main
 type DataIterator struct {
 	firstIndexIterator *FirstIndexIterator
 	db                 *MordorLogsDB
+	// Every nickname has a single first index entry, as in a sorted database,
+	// so its data is read by the offset from the iterator without searching the whole first index.
+	unique bool
 
 	// Used in the early stages of converting logs to a database.
 	data          map[string][]uint64
@@ -28,6 +31,18 @@ type DataIterator struct {
 }
 
 func (m *DataIterator) Next() (string, []*DataEntry, error) {
+	if m.unique {
+		nickname, offsetToSecondIndex, err := m.firstIndexIterator.Next()
+		if err != nil {
+			return "", nil, err
+		}
+		data, err := m.db.readDataBySecondIndex(offsetToSecondIndex)
+		if err != nil {
+			return "", nil, err
+		}
+		return nickname, data, nil
+	}
+
 	if m.usedNickNames == nil {
 		m.usedNickNames = make(map[string]bool)
 	}
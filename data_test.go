@@ -0,0 +1,175 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testDataEntry struct {
+	name     string
+	nickname string
+	entry    DataEntry
+}
+
+var testDataEntrys = []testDataEntry{
+	{"IPv4", "Mike_Tyson", DataEntry{time.Unix(1596315888, 0), net.ParseIP("10.0.2.4").To4(), "10", "Xiaomi", "Mi 10", "fp/dev4", "1.1.1.1:7777"}},
+	{"IPv6", "John_Smith", DataEntry{time.Unix(1596315888, 0), net.ParseIP("2001:db8::1"), "11", "Samsung", "S20", "fp/john", "[2001:db8::2]:7777"}},
+	{"IPv4-mapped IPv6", "john_doe", DataEntry{time.Unix(1596315888, 0), net.ParseIP("::ffff:172.16.0.1").To4(), "11", "Samsung", "S20", "fp/doe", "1.1.1.1:7777"}},
+	{"longest nickname", strings.Repeat("N", MaxNickNameLength), DataEntry{time.Unix(1596315888, 0), net.ParseIP("10.0.2.4").To4(), "10", "Xiaomi", "Mi 10", "fp/dev4", "1.1.1.1:7777"}},
+	{"longest entry", strings.Repeat("N", MaxNickNameLength), DataEntry{time.Unix(1596315888, 0), net.ParseIP("2001:db8::1"),
+		strings.Repeat("a", 255), strings.Repeat("b", 255), strings.Repeat("c", 255), strings.Repeat("d", 255), strings.Repeat("e", 255)}},
+	{"empty strings", "Bob", DataEntry{time.Unix(0, 0), net.IPv4(0, 0, 0, 0).To4(), "", "", "", "", ""}},
+	{"longer than one read", "Alice", DataEntry{time.Unix(1596315888, 0), net.ParseIP("192.168.1.1").To4(),
+		strings.Repeat("a", 255), strings.Repeat("b", 255), strings.Repeat("c", 255), strings.Repeat("d", 255), strings.Repeat("e", 255)}},
+}
+
+func TestDataEntryEncoding(t *testing.T) {
+	for _, test := range testDataEntrys {
+		record, err := encodeDataEntry(test.nickname, &test.entry)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := 2 + int(binary.LittleEndian.Uint16(record)); got != len(record) {
+			t.Errorf("%s: RecordLength covers %d bytes, record has %d", test.name, got, len(record))
+		}
+		nickname, entry, err := decodeDataEntry(record[2:])
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if nickname != test.nickname || !entry.Equal(&test.entry) || len(entry.IP) != len(test.entry.IP) {
+			t.Errorf("%s: decoded %q %v, want %q %v", test.name, nickname, entry, test.nickname, test.entry)
+		}
+
+		for i := 2; i < len(record); i++ {
+			if _, _, err := decodeDataEntry(record[2:i]); err == nil {
+				t.Errorf("%s: %d of %d bytes decoded", test.name, i-2, len(record)-2)
+				break
+			}
+		}
+		for i := 6; i < len(record); i++ {
+			damaged := append([]byte(nil), record...)
+			damaged[i] ^= 0x01
+			// A changed NickNameLength can point past the end of the entry.
+			if _, _, err := decodeDataEntry(damaged[2:]); err != ErrChecksumMismatch && !(i < 8 && err == ErrCorrupted) {
+				t.Errorf("%s: byte %d changed, error %v", test.name, i, err)
+				break
+			}
+		}
+	}
+}
+
+func TestDataEntryValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		nickname string
+		entry    DataEntry
+		err      error
+	}{
+		{"no IP", "Bob", DataEntry{}, ErrInvalidIP},
+		{"long string", "Bob", DataEntry{IP: net.ParseIP("1.1.1.1"), Model: strings.Repeat("m", 256)}, ErrLongStr},
+		{"long nickname", strings.Repeat("n", MaxNickNameLength+1), DataEntry{IP: net.ParseIP("1.1.1.1")}, ErrLongNickName},
+	}
+	for _, test := range tests {
+		if _, err := encodeDataEntry(test.nickname, &test.entry); err != test.err {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestDataFileReadEntry(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "mordorlogs-test-data-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+	filePath := filepath.Join(dirPath, "data.bin")
+
+	var data DataFile
+	if _, err := data.Open(filePath); err != nil {
+		t.Fatal(err)
+	}
+	offsets := make([]uint64, len(testDataEntrys))
+	for i, test := range testDataEntrys {
+		if offsets[i], err = data.WriteEntry(test.nickname, test.entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := data.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mapped := range []bool{false, true} {
+		var data DataFile
+		if err := data.OpenReadOnly(filePath); err != nil {
+			t.Fatal(err)
+		}
+		if mapped {
+			if err := data.Map(); err == ErrMmapUnsupported {
+				data.Close()
+				continue
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, test := range testDataEntrys {
+			nickname, entry, err := data.ReadEntryWithNickNameAt(offsets[i])
+			if err != nil {
+				t.Errorf("%s (mapped %v): %v", test.name, mapped, err)
+				continue
+			}
+			if nickname != test.nickname || !entry.Equal(&test.entry) {
+				t.Errorf("%s (mapped %v): read %q %v, want %q %v", test.name, mapped, nickname, entry, test.nickname, test.entry)
+			}
+		}
+		if _, err := data.WriteEntry("Bob", testDataEntrys[0].entry); err != ErrReadOnly {
+			t.Errorf("mapped %v: write error %v, want ErrReadOnly", mapped, err)
+		}
+		data.Close()
+	}
+}
+
+func TestDataEntryIP(t *testing.T) {
+	tests := []struct {
+		ip   net.IP
+		want net.IP
+	}{
+		{net.ParseIP("10.0.2.4"), net.IP{10, 0, 2, 4}},
+		{net.IP{10, 0, 2, 4}, net.IP{10, 0, 2, 4}},
+		{net.ParseIP("::ffff:10.0.2.4"), net.IP{10, 0, 2, 4}},
+		{net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::1")},
+		{net.ParseIP("::1"), net.ParseIP("::1")},
+		{nil, nil},
+		{net.IP{1, 2, 3}, nil},
+	}
+	for _, test := range tests {
+		if got := dataEntryIP(test.ip); !bytes.Equal(got, test.want) {
+			t.Errorf("dataEntryIP(%v) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
@@ -21,11 +21,18 @@ package main
 import "errors"
 
 var ErrCorrupted = errors.New("database is corrupted")
-var ErrIncompatibleVersions = errors.New("database has incompatible versions")
+var ErrIncompatibleVersions = errors.New("database has incompatible versions, run mordorlogs migrate")
 var ErrEmptySlice = errors.New("empty slice")
 var ErrEntryNotFound = errors.New("entry not found")
 var ErrLongNickName = errors.New("nickname too long")
-var ErrLongIP = errors.New("only IPv4 address allowed")
+var ErrInvalidIP = errors.New("only IPv4 and IPv6 addresses allowed")
 var ErrLongStr = errors.New("max string length 255 characters")
 var ErrIterationDone = errors.New("no more items in iterator")
 var ErrNullPointer = errors.New("null pointer")
+var ErrIndexNotFound = errors.New("index file not found, rebuild the database")
+var ErrNoCluster = errors.New("nickname has no linked accounts")
+var ErrInvalidQuery = errors.New("invalid query")
+var ErrQueryTimeout = errors.New("query took too long")
+var ErrMmapUnsupported = errors.New("memory mapping is not supported on this platform")
+var ErrReadOnly = errors.New("database is opened read-only")
+var ErrChecksumMismatch = errors.New("checksum mismatch, database is corrupted")
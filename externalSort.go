@@ -0,0 +1,244 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+/* Run file: Records sorted in memory and flushed to disk when the memory limit is reached.
+===============================RunRecord==============================
+	RecordLength		= 4 byte
+	Record				= RecordLength byte
+===============================RunRecord==============================
+*/
+
+// Approximate memory used by a record in addition to its bytes (slice header and allocator overhead).
+const externalSortRecordOverhead = 40
+
+const externalSortBufferSize = 64 * 1024
+
+// Sorts records that may not fit into memory. Records are kept in memory until memoryLimit is reached,
+// then sorted and written to a temporary run file. The runs are merged with a k-way merge.
+// The sort is stable: equal records are returned in the order they were added.
+type ExternalSorter struct {
+	tmpDirPath  string
+	memoryLimit int
+	less        func(a, b []byte) bool
+
+	records     [][]byte
+	memoryUsed  int
+	runs        []*os.File
+	recordCount int
+}
+
+func NewExternalSorter(tmpDirPath string, memoryLimit int, less func(a, b []byte) bool) *ExternalSorter {
+	return &ExternalSorter{tmpDirPath: tmpDirPath, memoryLimit: memoryLimit, less: less}
+}
+
+func (m *ExternalSorter) GetRecordCount() int {
+	return m.recordCount
+}
+
+// The record is copied, so the caller may reuse it.
+func (m *ExternalSorter) Add(record []byte) error {
+	rec := make([]byte, len(record))
+	copy(rec, record)
+	m.records = append(m.records, rec)
+	m.memoryUsed += len(rec) + externalSortRecordOverhead
+	m.recordCount++
+
+	if m.memoryUsed >= m.memoryLimit {
+		return m.writeRun()
+	}
+	return nil
+}
+
+func (m *ExternalSorter) sortRecords() {
+	sort.SliceStable(m.records, func(i, j int) bool { return m.less(m.records[i], m.records[j]) })
+}
+
+func (m *ExternalSorter) writeRun() error {
+	m.sortRecords()
+
+	file, err := ioutil.TempFile(m.tmpDirPath, "run-")
+	if err != nil {
+		return err
+	}
+	m.runs = append(m.runs, file)
+
+	w := bufio.NewWriterSize(file, externalSortBufferSize)
+	length := make([]byte, 4)
+	for _, rec := range m.records {
+		binary.LittleEndian.PutUint32(length, uint32(len(rec)))
+		if _, err := w.Write(length); err != nil {
+			return err
+		}
+		if _, err := w.Write(rec); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	m.records = nil
+	m.memoryUsed = 0
+	return nil
+}
+
+// No records can be added after Sort.
+func (m *ExternalSorter) Sort() (*ExternalSortIterator, error) {
+	if len(m.runs) == 0 {
+		// Everything fits into memory.
+		m.sortRecords()
+		return &ExternalSortIterator{records: m.records}, nil
+	}
+
+	if len(m.records) != 0 {
+		if err := m.writeRun(); err != nil {
+			return nil, err
+		}
+	}
+
+	it := &ExternalSortIterator{merge: &runMergeHeap{less: m.less}}
+	for i, file := range m.runs {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		run := &runReader{reader: bufio.NewReaderSize(file, externalSortBufferSize), index: i}
+		if err := run.next(); err == io.EOF {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		it.merge.runs = append(it.merge.runs, run)
+	}
+	heap.Init(it.merge)
+	return it, nil
+}
+
+// Removes the run files.
+func (m *ExternalSorter) Close() error {
+	var firstErr error
+	for _, file := range m.runs {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := os.Remove(file.Name()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	m.runs = nil
+	m.records = nil
+	return firstErr
+}
+
+type ExternalSortIterator struct {
+	// Used when every record fits into memory.
+	records [][]byte
+	offset  int
+
+	merge *runMergeHeap
+}
+
+func (m *ExternalSortIterator) Next() ([]byte, error) {
+	if m.merge == nil {
+		if m.offset < len(m.records) {
+			m.offset++
+			return m.records[m.offset-1], nil
+		}
+		return nil, ErrIterationDone
+	}
+
+	if m.merge.Len() == 0 {
+		return nil, ErrIterationDone
+	}
+	run := m.merge.runs[0]
+	record := append([]byte(nil), run.record...)
+	if err := run.next(); err == io.EOF {
+		heap.Pop(m.merge)
+	} else if err != nil {
+		return nil, err
+	} else {
+		heap.Fix(m.merge, 0)
+	}
+	return record, nil
+}
+
+type runReader struct {
+	reader *bufio.Reader
+	index  int
+	length [4]byte
+	record []byte
+}
+
+func (m *runReader) next() error {
+	if _, err := io.ReadFull(m.reader, m.length[:]); err != nil {
+		return err
+	}
+	length := int(binary.LittleEndian.Uint32(m.length[:]))
+	if cap(m.record) < length {
+		m.record = make([]byte, length)
+	}
+	m.record = m.record[:length]
+	if _, err := io.ReadFull(m.reader, m.record); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+type runMergeHeap struct {
+	runs []*runReader
+	less func(a, b []byte) bool
+}
+
+func (m *runMergeHeap) Len() int { return len(m.runs) }
+
+func (m *runMergeHeap) Less(i, j int) bool {
+	a, b := m.runs[i], m.runs[j]
+	if m.less(a.record, b.record) {
+		return true
+	}
+	if m.less(b.record, a.record) {
+		return false
+	}
+	// Earlier runs hold earlier records, which keeps the merge stable.
+	return a.index < b.index
+}
+
+func (m *runMergeHeap) Swap(i, j int) { m.runs[i], m.runs[j] = m.runs[j], m.runs[i] }
+
+func (m *runMergeHeap) Push(x interface{}) { m.runs = append(m.runs, x.(*runReader)) }
+
+func (m *runMergeHeap) Pop() interface{} {
+	n := len(m.runs)
+	run := m.runs[n-1]
+	m.runs = m.runs[:n-1]
+	return run
+}
@@ -0,0 +1,106 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+)
+
+func TestExternalSorter(t *testing.T) {
+	tmpDirPath, err := ioutil.TempDir("", "mordorlogs-test-sort-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDirPath)
+
+	// Record: Key (1 byte) + number of the record (4 byte), only the key is compared.
+	lessKey := func(a, b []byte) bool { return a[0] < b[0] }
+	tests := []struct {
+		name        string
+		count       int
+		memoryLimit int
+		minRuns     int
+	}{
+		{"empty", 0, 1 << 20, 0},
+		{"in memory", 500, 1 << 20, 0},
+		{"run per record", 50, 1, 50},
+		{"several runs", 1000, 100 * (5 + externalSortRecordOverhead), 10},
+	}
+	for _, test := range tests {
+		rnd := rand.New(rand.NewSource(int64(test.count)))
+		records := make([][]byte, test.count)
+		sorter := NewExternalSorter(tmpDirPath, test.memoryLimit, lessKey)
+		for i := range records {
+			records[i] = make([]byte, 5)
+			records[i][0] = byte(rnd.Intn(16))
+			binary.LittleEndian.PutUint32(records[i][1:], uint32(i))
+			if err := sorter.Add(records[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sort.SliceStable(records, func(i, j int) bool { return lessKey(records[i], records[j]) })
+
+		it, err := sorter.Sort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sorter.runs) < test.minRuns {
+			t.Errorf("%s: %d run files, want at least %d", test.name, len(sorter.runs), test.minRuns)
+		}
+		if sorter.GetRecordCount() != test.count {
+			t.Errorf("%s: GetRecordCount() = %d, want %d", test.name, sorter.GetRecordCount(), test.count)
+		}
+		for i := 0; ; i++ {
+			rec, err := it.Next()
+			if err == ErrIterationDone {
+				if i != len(records) {
+					t.Errorf("%s: %d records returned, want %d", test.name, i, len(records))
+				}
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if i >= len(records) {
+				t.Errorf("%s: more than %d records returned", test.name, len(records))
+				break
+			}
+			if !bytes.Equal(rec, records[i]) {
+				t.Errorf("%s: record %d is %v, want %v", test.name, i, rec, records[i])
+				break
+			}
+		}
+		if err := sorter.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(tmpDirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("%d run files left after Close", len(files))
+	}
+}
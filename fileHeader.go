@@ -21,6 +21,8 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"hash/crc32"
+	"io"
 	"os"
 	"time"
 )
@@ -28,27 +30,53 @@ import (
 /* Contained in every file.
 ==============================FileHeader==============================
 	Marker				= 16 byte
-	Version				= 4 byte
+	Version				= 4 byte // Major, minor and patch in the upper 3 bytes, flags in the lowest byte.
 	BuildTime			= 8 byte
+	Checksum			= 4 byte // CRC32C of everything after the header, valid only with fileSealedFlag.
 ==============================FileHeader==============================
 */
 
-const fileHeaderSize = int64(16 + 4 + 8)
+const fileHeaderSize = int64(16 + 4 + 8 + 4)
+
+const fileChecksumOffset = int64(16 + 4 + 8)
 
 // Major, minor, patch.
-const CurrentDatabaseVersion = uint32(1)<<24 | uint32(0)<<16 | uint32(0)<<8
+// 1.1.0: data entrys start with their length.
+// 1.2.0: data entrys and whole files have CRC32C checksums.
+// 1.3.0: data entrys keep their nickname.
+// 1.4.0: data entrys and ip_index.bin keep IPv6 addresses.
+// 1.5.0: nicknames of first_index.bin are kept in first_index_nicknames.bin.
+// 1.6.0: the lowest byte of Version keeps flags, fileSealedFlag marks a written file checksum.
+// 1.7.0: NickNameLength of first index and data entrys is 2 bytes.
+// A new version must add its step to migrationSteps in the same change, otherwise older databases can't be migrated.
+const CurrentDatabaseVersion = uint32(1)<<24 | uint32(7)<<16 | uint32(0)<<8
+
+// Flags kept in the lowest byte of Version.
+const fileFlagsMask = uint32(0xff)
+
+// Set by WriteFileChecksum. Without it the file was never finished and Checksum means nothing, since a real CRC32C can be 0 too.
+const fileSealedFlag = uint32(0x01)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
 
 type FileHeader struct {
 	Marker    [16]byte
 	Version   uint32
 	BuildTime time.Time
+	Checksum  uint32
+	Sealed    bool
 }
 
 func (m *FileHeader) MarshalBinary() ([]byte, error) {
 	buff := make([]byte, fileHeaderSize)
 	copy(buff[:16], m.Marker[:])
-	binary.LittleEndian.PutUint32(buff[16:20], m.Version)
+	version := m.Version
+	if m.Sealed {
+		version |= fileSealedFlag
+	}
+	binary.LittleEndian.PutUint32(buff[16:20], version)
 	binary.LittleEndian.PutUint64(buff[20:28], uint64(m.BuildTime.Unix()))
+	binary.LittleEndian.PutUint32(buff[28:32], m.Checksum)
 	return buff, nil
 }
 
@@ -57,8 +85,11 @@ func (m *FileHeader) UnmarshalBinary(data []byte) error {
 		return ErrCorrupted
 	}
 	// copy(m.Marker[:], data[:16])
-	m.Version = binary.LittleEndian.Uint32(data[16:20])
+	version := binary.LittleEndian.Uint32(data[16:20])
+	m.Version = version &^ fileFlagsMask
+	m.Sealed = version&fileSealedFlag != 0
 	m.BuildTime = time.Unix(int64(binary.LittleEndian.Uint64(data[20:28])), 0)
+	m.Checksum = binary.LittleEndian.Uint32(data[28:32])
 	return nil
 }
 
@@ -92,3 +123,64 @@ func NewFileHeader(marker [16]byte) *FileHeader {
 		BuildTime: time.Now(),
 	}
 }
+
+// Opens an existing file for reading only and checks its header, returns the file and its size.
+func openFileReadOnly(filePath string, marker [16]byte) (*os.File, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	fh := NewFileHeader(marker)
+	if err := fh.readHeaderFromFile(file); err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if !fh.checkVersion() {
+		file.Close()
+		return nil, 0, ErrIncompatibleVersions
+	}
+	return file, stat.Size(), nil
+}
+
+// Computes the CRC32C of the file contents after the header.
+func fileChecksum(file *os.File) (uint32, error) {
+	h := crc32.New(crc32cTable)
+	if _, err := io.Copy(h, io.NewSectionReader(file, fileHeaderSize, 1<<62)); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// Stores the checksum of a finished file in its header and marks the file sealed. The file must not be written after that.
+func WriteFileChecksum(filePath string) error {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, fileHeaderSize)
+	if _, err := file.ReadAt(b, 0); err != nil {
+		file.Close()
+		return err
+	}
+	sum, err := fileChecksum(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	binary.LittleEndian.PutUint32(b[16:20], binary.LittleEndian.Uint32(b[16:20])|fileSealedFlag)
+	binary.LittleEndian.PutUint32(b[fileChecksumOffset:], sum)
+	if _, err := file.WriteAt(b[16:], 16); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
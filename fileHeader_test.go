@@ -0,0 +1,95 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileHeaderSealedFlag(t *testing.T) {
+	fh := NewFileHeader(dataHeaderMarker)
+	fh.Sealed = true
+	b, err := fh.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := NewFileHeader(dataHeaderMarker)
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Sealed || got.Version != CurrentDatabaseVersion || !got.checkVersion() {
+		t.Errorf("got version %s, sealed %v", formatVersion(got.Version), got.Sealed)
+	}
+}
+
+func TestWriteFileChecksum(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "mordorlogs-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+	filePath := filepath.Join(dirPath, "data.bin")
+
+	readHeader := func() *FileHeader {
+		file, err := os.Open(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		fh := NewFileHeader(dataHeaderMarker)
+		if err := fh.readHeaderFromFile(file); err != nil {
+			t.Fatal(err)
+		}
+		return fh
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewFileHeader(dataHeaderMarker).writeHeaderToFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("entrys")); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if fh := readHeader(); fh.Sealed || fh.Checksum != 0 {
+		t.Errorf("new file is sealed %v with checksum %08x", fh.Sealed, fh.Checksum)
+	}
+
+	if err := WriteFileChecksum(filePath); err != nil {
+		t.Fatal(err)
+	}
+	file, err = os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := fileChecksum(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fh := readHeader(); !fh.Sealed || fh.Checksum != want || fh.Version != CurrentDatabaseVersion {
+		t.Errorf("sealed file: sealed %v, checksum %08x, version %s, want checksum %08x", fh.Sealed, fh.Checksum, formatVersion(fh.Version), want)
+	}
+}
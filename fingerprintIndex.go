@@ -0,0 +1,51 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+/* Fingerprint index file: Key index file where the key is hash of the device fingerprint.
+Different fingerprints may have the same hash, so found entrys must be checked against the data file.
+Entrys with an empty fingerprint are not indexed.
+==========================FingerprintIndexKey=========================
+	FingerprintHash		= 8 byte // FNV-1a
+==========================FingerprintIndexKey=========================
+*/
+
+const fingerprintIndexKeySize = 8
+
+var fingerprintIndexHeaderMarker = [16]byte{'M', 'o', 'r', 'd', 'o', 'r', 'L', 'o', 'g', 's', 'D', 'B', 0x05, 0x05, 0x05, 0x05}
+
+func NewFingerprintIndexFile() *KeyIndexFile {
+	return NewKeyIndexFile(fingerprintIndexHeaderMarker, fingerprintIndexKeySize)
+}
+
+func fingerprintIndexKey(fingerprint string) []byte {
+	if fingerprint == "" {
+		return nil
+	}
+	h := fnv.New64a()
+	h.Write([]byte(fingerprint))
+	key := make([]byte, fingerprintIndexKeySize)
+	binary.BigEndian.PutUint64(key, h.Sum64())
+	return key
+}
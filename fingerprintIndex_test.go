@@ -0,0 +1,85 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFingerprintIndexKey(t *testing.T) {
+	if key := fingerprintIndexKey(""); key != nil {
+		t.Errorf("empty fingerprint has key %x", key)
+	}
+	key := fingerprintIndexKey("fp/dev4")
+	if len(key) != fingerprintIndexKeySize {
+		t.Fatalf("got %d byte key", len(key))
+	}
+	if !bytes.Equal(key, fingerprintIndexKey("fp/dev4")) {
+		t.Error("the key of the same fingerprint differs")
+	}
+	if bytes.Equal(key, fingerprintIndexKey("fp/dev5")) {
+		t.Error("different fingerprints have the same key")
+	}
+}
+
+func TestFindNickNamesByFingerprint(t *testing.T) {
+	logs := testLogs{"06.08.2020": {"No_Fingerprint": {testLogLine("06.08.2020 10:00:00", "10.1.1.1", "", "1.1.1.1:7777")}}}
+	for day, files := range sampleTestLogs {
+		logs[day] = files
+	}
+	mldb, remove := openTestDatabase(t, logs)
+	defer remove()
+
+	tests := []struct {
+		fingerprint string
+		want        []string
+		wantErr     error
+	}{
+		{"fp/dev4", []string{"Alice", "Mike_Tyson"}, nil},
+		{"fp/bob", []string{"[ABC]_Bob"}, nil},
+		{"fp/doe", []string{"john_doe"}, nil},
+		{"fp/none", nil, ErrEntryNotFound},
+		{"", nil, ErrEntryNotFound},
+	}
+	for _, test := range tests {
+		got, err := mldb.FindNickNamesByFingerprint(test.fingerprint)
+		if err != test.wantErr {
+			t.Errorf("%q: got error %v, want %v", test.fingerprint, err, test.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.fingerprint, got, test.want)
+		}
+	}
+
+	// Entrys without a fingerprint are not indexed.
+	if count := mldb.fingerprintIndex.GetEntryCount(); count != 8 {
+		t.Errorf("fingerprint index has %d entrys, want 8", count)
+	}
+
+	// A different fingerprint with the same hash is skipped.
+	_, err := mldb.findNickNamesByKey(mldb.fingerprintIndex, fingerprintIndexKey("fp/dev4"), func(data *DataEntry) bool {
+		return data.Fingerprint == "fp/other"
+	})
+	if err != ErrEntryNotFound {
+		t.Errorf("colliding fingerprint returned %v", err)
+	}
+}
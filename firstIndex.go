@@ -19,27 +19,35 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"os"
 )
 
 /* First index file: Offset to second index file.
 Calculate count of entrys: (fileSize - fileHeaderSize) / firstIndexEntrySize
+The nicknames are kept in the nickname heap file, so the entrys have a fixed size for binary search.
 ============================FisrtIndexEntry===========================
-	NickName			= 24 bytes
+	NickNameOffset		= 8 byte (from the end of the heap header)
+	NickNameLength		= 2 byte
 	Offset				= 8 byte
 ============================FisrtIndexEntry===========================
 */
 
-const firstIndexEntrySize = 32
+const firstIndexEntrySize = 8 + 2 + 8
+
+// Limited by RecordLength of data entrys: what is left of it after the longest IP address and strings.
+const MaxNickNameLength = 1<<16 - 1 - (4 + 2 + 8 + 1 + 16 + 5*(1+255))
 
 var firstIndexHeaderMarker = [16]byte{'M', 'o', 'r', 'd', 'o', 'r', 'L', 'o', 'g', 's', 'D', 'B', 0x01, 0x01, 0x01, 0x01}
 
 type FirstIndexFile struct {
 	file        *os.File
+	nicknames   NickNameHeapFile
 	writeOffset int64
 	entryCount  int
+	mapped      []byte // Set by Map, reads are served from it instead of the file.
+	bulk        *bulkWriter
+	readOnly    bool
 }
 
 func (m *FirstIndexFile) Open(filePath string) (isnew bool, err error) {
@@ -57,7 +65,7 @@ func (m *FirstIndexFile) Open(filePath string) (isnew bool, err error) {
 			return true, err
 		}
 		m.writeOffset = fileHeaderSize
-		return true, nil
+		isnew = true
 	} else {
 		if err := m.readHeader(); err != nil {
 			return false, err
@@ -65,17 +73,86 @@ func (m *FirstIndexFile) Open(filePath string) (isnew bool, err error) {
 		m.entryCount = int((fileSize - fileHeaderSize) / firstIndexEntrySize)
 		m.writeOffset = fileSize
 	}
-	return false, err
+	// The heap is opened after the header is checked, so it is never created next to an older first index.
+	heapIsNew, err := m.nicknames.Open(nicknameHeapPath(filePath))
+	if err != nil {
+		m.file.Close()
+		return isnew, err
+	}
+	if heapIsNew != isnew {
+		// One of the files has just been created and the other has already been created.
+		m.Close()
+		return isnew, ErrCorrupted
+	}
+	return isnew, nil
+}
+
+// Opens an existing file for reading only, writes return ErrReadOnly.
+func (m *FirstIndexFile) OpenReadOnly(filePath string) (err error) {
+	if m.file, m.writeOffset, err = openFileReadOnly(filePath, firstIndexHeaderMarker); err != nil {
+		return err
+	}
+	if err := m.nicknames.OpenReadOnly(nicknameHeapPath(filePath)); err != nil {
+		m.file.Close()
+		return err
+	}
+	m.entryCount = int((m.writeOffset - fileHeaderSize) / firstIndexEntrySize)
+	m.readOnly = true
+	return nil
 }
 
 func (m *FirstIndexFile) Close() error {
+	if err := m.nicknames.Close(); err != nil {
+		m.file.Close()
+		return err
+	}
+	if err := flushBulkWriter(m.bulk); err != nil {
+		m.file.Close()
+		return err
+	}
+	if m.mapped != nil {
+		if err := unmapFile(m.mapped); err != nil {
+			return err
+		}
+		m.mapped = nil
+	}
 	return m.file.Close()
 }
 
+// Maps the file and the nickname heap into memory. Nothing can be written to a mapped file.
+func (m *FirstIndexFile) Map() (err error) {
+	if err := m.nicknames.Map(); err != nil {
+		return err
+	}
+	m.mapped, err = mapFile(m.file, m.writeOffset)
+	m.readOnly = true
+	return err
+}
+
 func (m *FirstIndexFile) Sync() error {
+	if err := m.nicknames.Sync(); err != nil {
+		return err
+	}
+	if err := m.Flush(); err != nil {
+		return err
+	}
 	return m.file.Sync()
 }
 
+// Buffers written entrys in memory, up to bufferSize bytes. They can't be read before Flush.
+func (m *FirstIndexFile) EnableBulkWrite(bufferSize int) {
+	m.nicknames.EnableBulkWrite(bufferSize)
+	m.bulk = newBulkWriter(m.file, bufferSize)
+}
+
+// The heap is flushed first, so the entrys never point past the end of it.
+func (m *FirstIndexFile) Flush() error {
+	if err := m.nicknames.Flush(); err != nil {
+		return err
+	}
+	return flushBulkWriter(m.bulk)
+}
+
 func (m *FirstIndexFile) readHeader() error {
 	fh := NewFileHeader(firstIndexHeaderMarker)
 	if err := fh.readHeaderFromFile(m.file); err != nil {
@@ -100,92 +177,140 @@ func (m *FirstIndexFile) GetEntryCount() int {
 }
 
 func (m *FirstIndexFile) WriteEntry(nickname string, offset uint64) (uint64, error) {
-	if len(nickname) > 24 {
+	if m.readOnly {
+		return 0, ErrReadOnly
+	}
+	if len(nickname) > MaxNickNameLength {
 		return 0, ErrLongNickName
 	}
-	nick := make([]byte, 24)
-	copy(nick, nickname)
-	if _, err := m.file.WriteAt(nick, m.writeOffset); err != nil {
+	nicknameOffset, err := m.nicknames.WriteNickName(nickname)
+	if err != nil {
 		return 0, err
 	}
-	m.writeOffset += 24
-
-	b := make([]byte, 8)
-	binary.LittleEndian.PutUint64(b, offset)
-	if _, err := m.file.WriteAt(b, m.writeOffset); err != nil {
+	entry := make([]byte, firstIndexEntrySize)
+	binary.LittleEndian.PutUint64(entry[0:8], nicknameOffset)
+	binary.LittleEndian.PutUint16(entry[8:10], uint16(len(nickname)))
+	binary.LittleEndian.PutUint64(entry[10:18], offset)
+	if err := writeAt(m.file, m.bulk, entry, m.writeOffset); err != nil {
 		return 0, err
 	}
-	m.writeOffset += 8
+	m.writeOffset += firstIndexEntrySize
 
 	m.entryCount++
 
 	return uint64(m.writeOffset - fileHeaderSize), nil
 }
 
-// It is used when the data has not been sorted, which makes it impossible to apply binary search.
-func (m *FirstIndexFile) NoBinaryFindOffsetByNickName(nickname string) (uint64, error) {
-	nickLength := len(nickname)
-	if nickLength > 24 {
-		return 0, ErrLongNickName
+// Reads the n-th entry, n is also used as the nickname ID by key indexes.
+func (m *FirstIndexFile) ReadEntry(n int) (string, uint64, error) {
+	if n < 0 || n >= m.entryCount {
+		return "", 0, ErrEntryNotFound
 	}
-	nick := []byte(nickname)
-	entry := make([]byte, firstIndexEntrySize)
+	return m.readEntry(n)
+}
 
-	for offset := fileHeaderSize; offset < m.writeOffset; offset += firstIndexEntrySize {
-		if _, err := m.file.ReadAt(entry, offset); err != nil {
-			return 0, err
-		}
-		// Exact match only.
-		if bytes.Index(entry[:24], nick) == 0 && entry[nickLength+1] == 0x00 {
-			return binary.LittleEndian.Uint64(entry[24:32]), nil
-		}
+func (m *FirstIndexFile) readEntry(n int) (string, uint64, error) {
+	entry, err := m.readEntryBytes(n)
+	if err != nil {
+		return "", 0, err
 	}
+	nicknameOffset, nicknameLength, offset := parseFirstIndexEntry(entry)
+	nickname, err := m.nicknames.ReadNickName(nicknameOffset, nicknameLength)
+	if err != nil {
+		return "", 0, err
+	}
+	return nickname, offset, nil
+}
 
-	return 0, ErrEntryNotFound
+func (m *FirstIndexFile) readEntryBytes(n int) ([]byte, error) {
+	return readBytesAt(m.file, m.mapped, fileHeaderSize+int64(n)*firstIndexEntrySize, firstIndexEntrySize)
+}
+
+func parseFirstIndexEntry(entry []byte) (nicknameOffset uint64, nicknameLength int, offset uint64) {
+	return binary.LittleEndian.Uint64(entry[0:8]), int(binary.LittleEndian.Uint16(entry[8:10])), binary.LittleEndian.Uint64(entry[10:18])
+}
+
+// It is used when the data has not been sorted, which makes it impossible to apply binary search.
+func (m *FirstIndexFile) NoBinaryFindOffsetByNickName(nickname string) (uint64, error) {
+	offsets, err := m.findAllOffsets(nickname, true)
+	if err != nil {
+		return 0, err
+	}
+	return offsets[0], nil
 }
 
 func (m *FirstIndexFile) FindOffsetByNickName(nickname string) (uint64, error) {
+	_, offset, err := m.findEntry(nickname)
+	return offset, err
+}
+
+// Returns the number of the entry, which is used as the nickname ID by key indexes.
+func (m *FirstIndexFile) FindNickNameID(nickname string) (uint32, error) {
+	n, _, err := m.findEntry(nickname)
+	return uint32(n), err
+}
+
+// Binary search, returns the number of the entry and the offset to second index file.
+func (m *FirstIndexFile) findEntry(nickname string) (int, uint64, error) {
 	nickLength := len(nickname)
-	if nickLength > 24 {
-		return 0, ErrLongNickName
+	if nickLength > MaxNickNameLength {
+		return 0, 0, ErrLongNickName
 	}
-	entry := make([]byte, firstIndexEntrySize)
 
 	left := 0
 	right := m.entryCount - 1
 	for left <= right {
 		mid := (left + right) / 2
 
-		offset := fileHeaderSize + (int64(mid) * firstIndexEntrySize)
-		if _, err := m.file.ReadAt(entry, offset); err != nil {
-			return 0, err
-		}
-
-		entryNick := entry[:24]
-		if zeroIndex := bytes.IndexByte(entryNick, 0x00); zeroIndex != -1 {
-			entryNick = entryNick[:zeroIndex]
+		entryNickName, offset, err := m.readEntry(mid)
+		if err != nil {
+			return 0, 0, err
 		}
-		entryNickName := string(entryNick)
 
 		if nickname > entryNickName {
 			left = mid + 1
 		} else if nickname < entryNickName {
 			right = mid - 1
 		} else { // Match found.
-			return binary.LittleEndian.Uint64(entry[24:32]), nil
+			return mid, offset, nil
+		}
+	}
+
+	return 0, 0, ErrEntryNotFound
+}
+
+// Returns the number of the first entry whose nickname is not less than nickname.
+func (m *FirstIndexFile) LowerBound(nickname string) (int, error) {
+	left := 0
+	right := m.entryCount
+	for left < right {
+		mid := (left + right) / 2
+
+		entryNickName, _, err := m.readEntry(mid)
+		if err != nil {
+			return 0, err
+		}
+
+		if entryNickName < nickname {
+			left = mid + 1
+		} else {
+			right = mid
 		}
 	}
 
-	return 0, ErrEntryNotFound
+	return left, nil
 }
 
 // Iterates over all elements. Used in the early stages of converting logs to a database.
 func (m *FirstIndexFile) FindAllOffsetsByNickName(nickname string) ([]uint64, error) {
+	return m.findAllOffsets(nickname, false)
+}
+
+func (m *FirstIndexFile) findAllOffsets(nickname string, firstOnly bool) ([]uint64, error) {
 	nickLength := len(nickname)
-	if nickLength > 24 {
+	if nickLength > MaxNickNameLength {
 		return nil, ErrLongNickName
 	}
-	nick := []byte(nickname)
 	entry := make([]byte, firstIndexEntrySize)
 
 	offsets := make([]uint64, 0)
@@ -194,9 +319,21 @@ func (m *FirstIndexFile) FindAllOffsetsByNickName(nickname string) ([]uint64, er
 		if _, err := m.file.ReadAt(entry, offset); err != nil {
 			return nil, err
 		}
+		nicknameOffset, nicknameLength, offsetToSecondIndex := parseFirstIndexEntry(entry)
+		// Only nicknames of the same length are read from the heap.
+		if nicknameLength != nickLength {
+			continue
+		}
+		entryNickName, err := m.nicknames.ReadNickName(nicknameOffset, nicknameLength)
+		if err != nil {
+			return nil, err
+		}
 		// Exact match only.
-		if bytes.Index(entry[:24], nick) == 0 && entry[nickLength+1] == 0x00 {
-			offsets = append(offsets, binary.LittleEndian.Uint64(entry[24:32]))
+		if entryNickName == nickname {
+			offsets = append(offsets, offsetToSecondIndex)
+			if firstOnly {
+				break
+			}
 		}
 	}
 
@@ -208,5 +345,10 @@ func (m *FirstIndexFile) FindAllOffsetsByNickName(nickname string) ([]uint64, er
 }
 
 func (m *FirstIndexFile) Iterator() *FirstIndexIterator {
-	return &FirstIndexIterator{file: m.file, fileSize: m.writeOffset, offset: fileHeaderSize}
+	return m.IteratorAt(0)
+}
+
+// Iterates from the n-th entry to the end of the file.
+func (m *FirstIndexFile) IteratorAt(n int) *FirstIndexIterator {
+	return &FirstIndexIterator{index: m, n: n}
 }
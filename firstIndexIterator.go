@@ -18,33 +18,19 @@
 
 package main
 
-import (
-	"bytes"
-	"encoding/binary"
-	"os"
-)
-
 type FirstIndexIterator struct {
-	file     *os.File
-	fileSize int64
-	offset   int64
+	index *FirstIndexFile
+	n     int
 }
 
 func (m *FirstIndexIterator) Next() (string, uint64, error) {
-	entry := make([]byte, firstIndexEntrySize)
-
-	if m.offset < m.fileSize {
-		if _, err := m.file.ReadAt(entry, int64(m.offset)); err != nil {
+	if m.n < m.index.entryCount {
+		nickname, offset, err := m.index.readEntry(m.n)
+		if err != nil {
 			return "", 0, err
 		}
-		m.offset += firstIndexEntrySize
-
-		nick := entry[:24]
-		if zeroIndex := bytes.IndexByte(nick, 0x00); zeroIndex != -1 {
-			nick = nick[:zeroIndex]
-		}
-
-		return string(nick), binary.LittleEndian.Uint64(entry[24:32]), nil
+		m.n++
+		return nickname, offset, nil
 	}
 
 	return "", 0, ErrIterationDone
@@ -0,0 +1,136 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+)
+
+/* Folded index file: Nickname IDs sorted by lower case nickname, allows case-insensitive search.
+Calculate count of entrys: (fileSize - fileHeaderSize) / foldedIndexEntrySize
+============================FoldedIndexEntry==========================
+	NickNameID			= 4 byte
+============================FoldedIndexEntry==========================
+*/
+
+const foldedIndexEntrySize = 4
+
+var foldedIndexHeaderMarker = [16]byte{'M', 'o', 'r', 'd', 'o', 'r', 'L', 'o', 'g', 's', 'D', 'B', 0x07, 0x07, 0x07, 0x07}
+
+type FoldedIndexFile struct {
+	file        *os.File
+	writeOffset int64
+	entryCount  int
+	readOnly    bool
+}
+
+func (m *FoldedIndexFile) Open(filePath string) (isnew bool, err error) {
+	m.file, err = os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0755)
+	if err != nil {
+		return false, err
+	}
+	stat, err := m.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	fileSize := stat.Size()
+	if fileSize == 0 {
+		if err := m.writeHeader(); err != nil {
+			return true, err
+		}
+		m.writeOffset = fileHeaderSize
+		return true, nil
+	} else {
+		if err := m.readHeader(); err != nil {
+			return false, err
+		}
+		m.entryCount = int((fileSize - fileHeaderSize) / foldedIndexEntrySize)
+		m.writeOffset = fileSize
+	}
+	return false, err
+}
+
+// Opens an existing file for reading only, writes return ErrReadOnly.
+func (m *FoldedIndexFile) OpenReadOnly(filePath string) (err error) {
+	if m.file, m.writeOffset, err = openFileReadOnly(filePath, foldedIndexHeaderMarker); err != nil {
+		return err
+	}
+	m.entryCount = int((m.writeOffset - fileHeaderSize) / foldedIndexEntrySize)
+	m.readOnly = true
+	return nil
+}
+
+func (m *FoldedIndexFile) Close() error {
+	return m.file.Close()
+}
+
+func (m *FoldedIndexFile) Sync() error {
+	return m.file.Sync()
+}
+
+func (m *FoldedIndexFile) readHeader() error {
+	fh := NewFileHeader(foldedIndexHeaderMarker)
+	if err := fh.readHeaderFromFile(m.file); err != nil {
+		return err
+	}
+	if !fh.checkVersion() {
+		return ErrIncompatibleVersions
+	}
+	return nil
+}
+
+func (m *FoldedIndexFile) writeHeader() error {
+	fh := NewFileHeader(foldedIndexHeaderMarker)
+	if err := fh.writeHeaderToFile(m.file); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *FoldedIndexFile) GetEntryCount() int {
+	return m.entryCount
+}
+
+func (m *FoldedIndexFile) WriteEntry(nicknameID uint32) error {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	b := make([]byte, foldedIndexEntrySize)
+	binary.LittleEndian.PutUint32(b, nicknameID)
+	if _, err := m.file.WriteAt(b, m.writeOffset); err != nil {
+		return err
+	}
+	m.writeOffset += foldedIndexEntrySize
+	m.entryCount++
+	return nil
+}
+
+func (m *FoldedIndexFile) ReadEntry(n int) (uint32, error) {
+	b := make([]byte, foldedIndexEntrySize)
+	if _, err := m.file.ReadAt(b, fileHeaderSize+int64(n)*foldedIndexEntrySize); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func foldNickName(nickname string) string {
+	return strings.ToLower(nickname)
+}
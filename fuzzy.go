@@ -0,0 +1,163 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "sort"
+
+// Finds nicknames by edit distance, ignoring case. Built in memory from the first index (BK-tree).
+// The matcher is not updated by later writes to the database.
+type NickNameMatcher struct {
+	root  *bkTreeNode
+	count int
+}
+
+type bkTreeNode struct {
+	folded    string
+	nicknames []string // All nicknames with this folded form.
+	children  []bkTreeChild
+}
+
+type bkTreeChild struct {
+	distance int
+	node     *bkTreeNode
+}
+
+type nicknameMatch struct {
+	nickname string
+	distance int
+}
+
+func NewNickNameMatcher(it *FirstIndexIterator) (*NickNameMatcher, error) {
+	m := new(NickNameMatcher)
+	for {
+		nickname, _, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		m.Add(nickname)
+	}
+	return m, nil
+}
+
+func (m *NickNameMatcher) GetCount() int {
+	return m.count
+}
+
+func (m *NickNameMatcher) Add(nickname string) {
+	m.count++
+	folded := foldNickName(nickname)
+	if m.root == nil {
+		m.root = &bkTreeNode{folded: folded, nicknames: []string{nickname}}
+		return
+	}
+
+	node := m.root
+	for {
+		distance := levenshteinDistance(folded, node.folded)
+		if distance == 0 {
+			node.nicknames = append(node.nicknames, nickname)
+			return
+		}
+		var next *bkTreeNode
+		for _, child := range node.children {
+			if child.distance == distance {
+				next = child.node
+				break
+			}
+		}
+		if next == nil {
+			node.children = append(node.children, bkTreeChild{distance, &bkTreeNode{folded: folded, nicknames: []string{nickname}}})
+			return
+		}
+		node = next
+	}
+}
+
+// Returns at most limit nicknames within maxDistance edits, the closest first.
+func (m *NickNameMatcher) Find(nickname string, maxDistance int, limit int) []string {
+	if m.root == nil {
+		return nil
+	}
+	folded := foldNickName(nickname)
+
+	matches := make([]nicknameMatch, 0)
+	stack := []*bkTreeNode{m.root}
+	for len(stack) != 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		distance := levenshteinDistance(folded, node.folded)
+		if distance <= maxDistance {
+			for _, match := range node.nicknames {
+				matches = append(matches, nicknameMatch{match, distance})
+			}
+		}
+		// Triangle inequality: only children within maxDistance of the node distance can match.
+		for _, child := range node.children {
+			if child.distance >= distance-maxDistance && child.distance <= distance+maxDistance {
+				stack = append(stack, child.node)
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].nickname < matches[j].nickname
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	nicknames := make([]string, len(matches))
+	for i, match := range matches {
+		nicknames[i] = match.nickname
+	}
+	return nicknames
+}
+
+func levenshteinDistance(sa, sb string) int {
+	a, b := []rune(sa), []rune(sb)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,120 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestLevenshteinDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"Mike_Tyson", "Mike_Tyson", 0},
+		{"Mike_Tyson", "Mike_Tison", 1},
+		{"Mike_Tyson", "Mike_Tysonn", 1},
+		{"Mike_Tyson", "ike_Tyson", 1},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"Вася", "Васян", 1},
+		{"Вася", "Васа", 1},
+	}
+	for _, test := range tests {
+		if got := levenshteinDistance(test.a, test.b); got != test.want {
+			t.Errorf("levenshteinDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		if got := levenshteinDistance(test.b, test.a); got != test.want {
+			t.Errorf("levenshteinDistance(%q, %q) = %d, want %d", test.b, test.a, got, test.want)
+		}
+	}
+}
+
+func TestNickNameMatcher(t *testing.T) {
+	matcher := new(NickNameMatcher)
+	for _, nickname := range []string{"Mike_Tyson", "mike_tyson", "Mike_Tison", "Mike_Jackson", "John_Smith", "John_Smit", "Alice"} {
+		matcher.Add(nickname)
+	}
+
+	tests := []struct {
+		nickname    string
+		maxDistance int
+		limit       int
+		want        []string
+	}{
+		{"MIKE_TYSON", 0, 10, []string{"Mike_Tyson", "mike_tyson"}},
+		{"Mike_Tyson", 1, 10, []string{"Mike_Tyson", "mike_tyson", "Mike_Tison"}},
+		{"Mike_Tyson", 1, 2, []string{"Mike_Tyson", "mike_tyson"}},
+		{"John_Smth", 1, 10, []string{"John_Smith"}},
+		{"John_Smth", 2, 10, []string{"John_Smith", "John_Smit"}},
+		{"Alise", 1, 10, []string{"Alice"}},
+		{"Bob", 2, 10, []string{}},
+	}
+	for _, test := range tests {
+		if got := matcher.Find(test.nickname, test.maxDistance, test.limit); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Find(%q, %d, %d) = %q, want %q", test.nickname, test.maxDistance, test.limit, got, test.want)
+		}
+	}
+	if matcher.GetCount() != 7 {
+		t.Errorf("GetCount() = %d, want 7", matcher.GetCount())
+	}
+}
+
+// The tree must find the same nicknames as comparing with every nickname.
+func TestNickNameMatcherPruning(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	randomNickName := func() string {
+		b := make([]byte, 3+rnd.Intn(6))
+		for i := range b {
+			b[i] = "abcdAB_"[rnd.Intn(7)]
+		}
+		return string(b)
+	}
+	matcher := new(NickNameMatcher)
+	nicknames := make([]string, 2000)
+	for i := range nicknames {
+		nicknames[i] = randomNickName()
+		matcher.Add(nicknames[i])
+	}
+
+	for i := 0; i < 50; i++ {
+		query := randomNickName()
+		for maxDistance := 0; maxDistance <= 3; maxDistance++ {
+			want := make([]string, 0)
+			for _, nickname := range nicknames {
+				if levenshteinDistance(foldNickName(query), foldNickName(nickname)) <= maxDistance {
+					want = append(want, nickname)
+				}
+			}
+			got := matcher.Find(query, maxDistance, len(nicknames))
+			sort.Strings(want)
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("Find(%q, %d) = %q, want %q", query, maxDistance, got, want)
+			}
+		}
+	}
+}
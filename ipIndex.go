@@ -0,0 +1,45 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "net"
+
+/* IP index file: Key index file where the key is IP address of the data entry.
+IPv4 addresses are stored as IPv4-mapped IPv6 addresses (::ffff:1.2.3.4), so both families
+share one index and every IPv4 subnet is still a continuous range of keys.
+==============================IPIndexKey==============================
+	IP					= 16 byte
+==============================IPIndexKey==============================
+*/
+
+const ipIndexKeySize = net.IPv6len
+
+var ipIndexHeaderMarker = [16]byte{'M', 'o', 'r', 'd', 'o', 'r', 'L', 'o', 'g', 's', 'D', 'B', 0x04, 0x04, 0x04, 0x04}
+
+func NewIPIndexFile() *KeyIndexFile {
+	return NewKeyIndexFile(ipIndexHeaderMarker, ipIndexKeySize)
+}
+
+func ipIndexKey(ip net.IP) ([]byte, error) {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return nil, ErrInvalidIP
+	}
+	return append([]byte(nil), ip16...), nil
+}
@@ -0,0 +1,129 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestFindNickNamesByIP(t *testing.T) {
+	mldb, remove := openTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	tests := []struct {
+		ip      string
+		want    []string
+		wantErr error
+	}{
+		{"192.168.1.1", []string{"[ABC]_Bob", "[ABC]_Carl"}, nil},
+		{"10.0.2.4", []string{"Mike_Tyson"}, nil},
+		{"172.16.0.1", []string{"john_doe"}, nil},
+		{"::ffff:172.16.0.1", []string{"john_doe"}, nil},
+		{"0.0.0.0", nil, ErrEntryNotFound},
+		{"10.9.9.9", nil, ErrEntryNotFound},
+		{"255.255.255.255", nil, ErrEntryNotFound},
+		{"2001:db8::1", []string{"John_Smith"}, nil},
+		{"2001:db8::3", nil, ErrEntryNotFound},
+	}
+	for _, test := range tests {
+		got, err := mldb.FindNickNamesByIP(net.ParseIP(test.ip))
+		if err != test.wantErr {
+			t.Errorf("%s: got error %v, want %v", test.ip, err, test.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestFindByIPRange(t *testing.T) {
+	mldb, remove := openTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	tests := []struct {
+		subnet string
+		want   []string // Ordered by IP address.
+	}{
+		{"10.0.2.4/32", []string{
+			"Mike_Tyson 02.08.2020 06:26:11 10.0.2.4",
+			"Mike_Tyson 05.08.2020 12:00:00 10.0.2.4",
+		}},
+		{"10.0.0.0/16", []string{
+			"Mike_Tyson 02.08.2020 19:55:11 10.0.0.2",
+			"Mike_Tyson 02.08.2020 06:26:11 10.0.2.4",
+			"Mike_Tyson 05.08.2020 12:00:00 10.0.2.4",
+		}},
+		{"10.0.0.0/23", []string{
+			"Mike_Tyson 02.08.2020 19:55:11 10.0.0.2",
+		}},
+		{"192.168.1.0/24", []string{
+			"[ABC]_Bob 02.08.2020 10:00:00 192.168.1.1",
+			"[ABC]_Carl 05.08.2020 11:00:00 192.168.1.1",
+		}},
+		{"0.0.0.0/0", []string{
+			"Mike_Tyson 02.08.2020 19:55:11 10.0.0.2",
+			"Mike_Tyson 02.08.2020 06:26:11 10.0.2.4",
+			"Mike_Tyson 05.08.2020 12:00:00 10.0.2.4",
+			"john_doe 05.08.2020 23:59:59 172.16.0.1",
+			"[ABC]_Bob 02.08.2020 10:00:00 192.168.1.1",
+			"[ABC]_Carl 05.08.2020 11:00:00 192.168.1.1",
+		}},
+		{"2001:db8::1/128", []string{
+			"John_Smith 02.08.2020 21:04:48 2001:db8::1",
+		}},
+		{"2001:db8::/32", []string{
+			"John_Smith 02.08.2020 21:04:48 2001:db8::1",
+			"Alice 05.08.2020 08:30:00 2001:db8::2",
+		}},
+		{"::ffff:10.0.0.0/112", []string{
+			"Mike_Tyson 02.08.2020 19:55:11 10.0.0.2",
+			"Mike_Tyson 02.08.2020 06:26:11 10.0.2.4",
+			"Mike_Tyson 05.08.2020 12:00:00 10.0.2.4",
+		}},
+		{"::/0", []string{
+			"Mike_Tyson 02.08.2020 19:55:11 10.0.0.2",
+			"Mike_Tyson 02.08.2020 06:26:11 10.0.2.4",
+			"Mike_Tyson 05.08.2020 12:00:00 10.0.2.4",
+			"john_doe 05.08.2020 23:59:59 172.16.0.1",
+			"[ABC]_Bob 02.08.2020 10:00:00 192.168.1.1",
+			"[ABC]_Carl 05.08.2020 11:00:00 192.168.1.1",
+			"John_Smith 02.08.2020 21:04:48 2001:db8::1",
+			"Alice 05.08.2020 08:30:00 2001:db8::2",
+		}},
+		{"2001:db9::/32", []string{}},
+		{"11.0.0.0/8", []string{}},
+		{"255.255.255.255/32", []string{}},
+	}
+	for _, test := range tests {
+		_, subnet, err := net.ParseCIDR(test.subnet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := mldb.FindByIPRange(*subnet)
+		if err != nil {
+			t.Fatalf("%s: %v", test.subnet, err)
+		}
+		if got := readTestEntrys(t, it); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.subnet, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+)
+
+/* Key index file: Data entrys sorted by a fixed size key (IP address and so on).
+Keys are stored in big endian, so comparing them as bytes gives the right order.
+Entrys with equal keys are sorted by NickNameID and then by DataOffset.
+Calculate count of entrys: (fileSize - fileHeaderSize) / (keySize + keyIndexEntryTailSize)
+=============================KeyIndexEntry============================
+	Key					= keySize byte
+	NickNameID			= 4 byte // Number of the entry in the sorted first index.
+	DataOffset			= 8 byte
+=============================KeyIndexEntry============================
+*/
+
+const keyIndexEntryTailSize = 4 + 8
+
+type KeyIndexFile struct {
+	file        *os.File
+	marker      [16]byte
+	keySize     int
+	entrySize   int64
+	writeOffset int64
+	entryCount  int
+	readOnly    bool
+}
+
+func NewKeyIndexFile(marker [16]byte, keySize int) *KeyIndexFile {
+	return &KeyIndexFile{marker: marker, keySize: keySize, entrySize: int64(keySize + keyIndexEntryTailSize)}
+}
+
+func (m *KeyIndexFile) Open(filePath string) (isnew bool, err error) {
+	m.file, err = os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0755)
+	if err != nil {
+		return false, err
+	}
+	stat, err := m.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	fileSize := stat.Size()
+	if fileSize == 0 {
+		if err := m.writeHeader(); err != nil {
+			return true, err
+		}
+		m.writeOffset = fileHeaderSize
+		return true, nil
+	} else {
+		if err := m.readHeader(); err != nil {
+			return false, err
+		}
+		m.entryCount = int((fileSize - fileHeaderSize) / m.entrySize)
+		m.writeOffset = fileSize
+	}
+	return false, err
+}
+
+// Opens an existing file for reading only, writes return ErrReadOnly.
+func (m *KeyIndexFile) OpenReadOnly(filePath string) (err error) {
+	if m.file, m.writeOffset, err = openFileReadOnly(filePath, m.marker); err != nil {
+		return err
+	}
+	m.entryCount = int((m.writeOffset - fileHeaderSize) / m.entrySize)
+	m.readOnly = true
+	return nil
+}
+
+func (m *KeyIndexFile) Close() error {
+	return m.file.Close()
+}
+
+func (m *KeyIndexFile) Sync() error {
+	return m.file.Sync()
+}
+
+func (m *KeyIndexFile) readHeader() error {
+	fh := NewFileHeader(m.marker)
+	if err := fh.readHeaderFromFile(m.file); err != nil {
+		return err
+	}
+	if !fh.checkVersion() {
+		return ErrIncompatibleVersions
+	}
+	return nil
+}
+
+func (m *KeyIndexFile) writeHeader() error {
+	fh := NewFileHeader(m.marker)
+	if err := fh.writeHeaderToFile(m.file); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *KeyIndexFile) GetEntryCount() int {
+	return m.entryCount
+}
+
+func (m *KeyIndexFile) GetKeySize() int {
+	return m.keySize
+}
+
+func (m *KeyIndexFile) WriteEntry(key []byte, nicknameID uint32, dataOffset uint64) error {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	if len(key) != m.keySize {
+		return ErrCorrupted
+	}
+	entry := make([]byte, m.entrySize)
+	copy(entry, key)
+	binary.LittleEndian.PutUint32(entry[m.keySize:], nicknameID)
+	binary.LittleEndian.PutUint64(entry[m.keySize+4:], dataOffset)
+	if _, err := m.file.WriteAt(entry, m.writeOffset); err != nil {
+		return err
+	}
+	m.writeOffset += m.entrySize
+	m.entryCount++
+	return nil
+}
+
+func (m *KeyIndexFile) ReadEntry(n int) ([]byte, uint32, uint64, error) {
+	entry := make([]byte, m.entrySize)
+	if _, err := m.file.ReadAt(entry, fileHeaderSize+int64(n)*m.entrySize); err != nil {
+		return nil, 0, 0, err
+	}
+	key, nicknameID, dataOffset := m.parseEntry(entry)
+	return key, nicknameID, dataOffset, nil
+}
+
+func (m *KeyIndexFile) parseEntry(entry []byte) ([]byte, uint32, uint64) {
+	return entry[:m.keySize], binary.LittleEndian.Uint32(entry[m.keySize:]), binary.LittleEndian.Uint64(entry[m.keySize+4:])
+}
+
+// Returns the number of the first entry whose key is not less than key.
+func (m *KeyIndexFile) LowerBound(key []byte) (int, error) {
+	if len(key) != m.keySize {
+		return 0, ErrCorrupted
+	}
+	entryKey := make([]byte, m.keySize)
+
+	left := 0
+	right := m.entryCount
+	for left < right {
+		mid := (left + right) / 2
+
+		offset := fileHeaderSize + int64(mid)*m.entrySize
+		if _, err := m.file.ReadAt(entryKey, offset); err != nil {
+			return 0, err
+		}
+
+		if bytes.Compare(entryKey, key) < 0 {
+			left = mid + 1
+		} else {
+			right = mid
+		}
+	}
+
+	return left, nil
+}
+
+// Iterates from the n-th entry to the end of the file.
+func (m *KeyIndexFile) IteratorAt(n int) *KeyIndexIterator {
+	return &KeyIndexIterator{index: m, fileSize: m.writeOffset, offset: fileHeaderSize + int64(n)*m.entrySize}
+}
+
+func (m *KeyIndexFile) Iterator() *KeyIndexIterator {
+	return m.IteratorAt(0)
+}
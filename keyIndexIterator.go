@@ -0,0 +1,39 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+type KeyIndexIterator struct {
+	index    *KeyIndexFile
+	fileSize int64
+	offset   int64
+}
+
+// Returns the key, the nickname ID and the offset to data file.
+func (m *KeyIndexIterator) Next() ([]byte, uint32, uint64, error) {
+	if m.offset < m.fileSize {
+		entry := make([]byte, m.index.entrySize)
+		if _, err := m.index.file.ReadAt(entry, m.offset); err != nil {
+			return nil, 0, 0, err
+		}
+		m.offset += m.index.entrySize
+		key, nicknameID, dataOffset := m.index.parseEntry(entry)
+		return key, nicknameID, dataOffset, nil
+	}
+	return nil, 0, 0, ErrIterationDone
+}
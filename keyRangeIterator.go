@@ -0,0 +1,77 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "bytes"
+
+// Iterates over key index entrys with keys from first to last inclusive, reading their data one by one.
+type KeyRangeIterator struct {
+	db   *MordorLogsDB
+	it   *KeyIndexIterator
+	last []byte
+	// Entrys that don't match are skipped, nil to return every entry.
+	match func(data *DataEntry) bool
+
+	// Neighbouring entrys usually belong to the same nickname.
+	nicknameID uint32
+	nickname   string
+}
+
+// Only entrys from the game server will be returned.
+func (m *KeyRangeIterator) FilterByServer(server string) *KeyRangeIterator {
+	m.addMatch(func(data *DataEntry) bool { return data.Server == server })
+	return m
+}
+
+func (m *KeyRangeIterator) addMatch(match func(data *DataEntry) bool) {
+	if m.match == nil {
+		m.match = match
+		return
+	}
+	prev := m.match
+	m.match = func(data *DataEntry) bool { return prev(data) && match(data) }
+}
+
+func (m *KeyRangeIterator) Next() (string, *DataEntry, error) {
+	for {
+		key, nicknameID, dataOffset, err := m.it.Next()
+		if err != nil {
+			return "", nil, err
+		}
+		if bytes.Compare(key, m.last) > 0 {
+			return "", nil, ErrIterationDone
+		}
+
+		data, err := m.db.ReadDataEntryAt(dataOffset)
+		if err != nil {
+			return "", nil, err
+		}
+		if m.match != nil && !m.match(data) {
+			continue
+		}
+
+		if m.nickname == "" || nicknameID != m.nicknameID {
+			if m.nickname, err = m.db.GetNickNameByID(nicknameID); err != nil {
+				return "", nil, err
+			}
+			m.nicknameID = nicknameID
+		}
+		return m.nickname, data, nil
+	}
+}
@@ -21,6 +21,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -28,10 +29,14 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 )
 
 // ConvertLogsToDatabase => MemSortDatabase => SortFirstIndex => SortSecondIndex
+// When the index doesn't fit into memory: ConvertLogsToDatabase => ExternalSortDatabase => ExternalSortFirstIndex => SortSecondIndex
+// Key indexes (ip_index.bin and others) and clusters are built last: BuildKeyIndexes => BuildClusters
+// Lost or damaged first and second indexes are rebuilt from the data file alone: RebuildIndexes
 
 type firstIndexItem struct {
 	NickName string
@@ -43,6 +48,8 @@ func SortFirstIndex(from *MordorLogsDB, firstIndexFile string) error {
 	if _, err := firstIndex.Open(firstIndexFile); err != nil {
 		return err
 	}
+	defer firstIndex.Close()
+	firstIndex.EnableBulkWrite(DefaultBulkWriteBufferSize)
 
 	items := make([]firstIndexItem, 0, from.GetEntryCount())
 	it := from.FirstIndexIterator()
@@ -59,13 +66,12 @@ func SortFirstIndex(from *MordorLogsDB, firstIndexFile string) error {
 	sort.SliceStable(items, func(i, j int) bool { return items[i].NickName < items[j].NickName })
 
 	for _, v := range items {
-		fmt.Println(v.NickName, v.Offset)
 		if _, err := firstIndex.WriteEntry(v.NickName, v.Offset); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return firstIndex.Sync()
 }
 
 type secondIndexItem struct {
@@ -78,6 +84,8 @@ func SortSecondIndex(from *MordorLogsDB, secondIndexFile string) error {
 	if _, err := secondIndex.Open(secondIndexFile); err != nil {
 		return err
 	}
+	defer secondIndex.Close()
+	secondIndex.EnableBulkWrite(DefaultBulkWriteBufferSize)
 
 	it := from.SecondIndexIterator()
 	for {
@@ -102,12 +110,398 @@ func SortSecondIndex(from *MordorLogsDB, secondIndexFile string) error {
 		for i, v := range items {
 			offsets[i] = v.Offset
 		}
-		secondIndex.WriteEntry(offsets)
+		if _, err := secondIndex.WriteEntry(offsets); err != nil {
+			return err
+		}
+	}
+
+	return secondIndex.Sync()
+}
+
+// Record used to sort first index entrys with ExternalSorter: Offset (8 byte) + NickName.
+func newNickNameRecord(nickname string, offset uint64) []byte {
+	rec := make([]byte, 8+len(nickname))
+	binary.LittleEndian.PutUint64(rec[:8], offset)
+	copy(rec[8:], nickname)
+	return rec
+}
+
+func lessNickNameRecord(a, b []byte) bool {
+	return bytes.Compare(a[8:], b[8:]) < 0
+}
+
+func parseNickNameRecord(rec []byte) (string, uint64) {
+	return string(rec[8:]), binary.LittleEndian.Uint64(rec[:8])
+}
+
+// The same as SortFirstIndex, but keeps at most memoryLimit bytes of entrys in memory.
+func ExternalSortFirstIndex(from *MordorLogsDB, firstIndexFile string, tmpDirPath string, memoryLimit int) error {
+	var firstIndex FirstIndexFile
+	if _, err := firstIndex.Open(firstIndexFile); err != nil {
+		return err
+	}
+	defer firstIndex.Close()
+	firstIndex.EnableBulkWrite(DefaultBulkWriteBufferSize)
+
+	sorter := NewExternalSorter(tmpDirPath, memoryLimit, lessNickNameRecord)
+	defer sorter.Close()
+
+	it := from.FirstIndexIterator()
+	for {
+		nickname, offset, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := sorter.Add(newNickNameRecord(nickname, offset)); err != nil {
+			return err
+		}
+	}
+
+	sorted, err := sorter.Sort()
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := sorted.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return err
+		}
+		if _, err := firstIndex.WriteEntry(parseNickNameRecord(rec)); err != nil {
+			return err
+		}
+	}
+
+	return firstIndex.Sync()
+}
+
+// The same as MemSortDatabase, but groups offsets by nickname with an external sort
+// instead of a map, keeping at most memoryLimit bytes of offsets in memory.
+// Nicknames are written in sorted order.
+func ExternalSortDatabase(from *MordorLogsDB, to *MordorLogsDB, tmpDirPath string, memoryLimit int) error {
+	sorter := NewExternalSorter(tmpDirPath, memoryLimit, lessNickNameRecord)
+	defer sorter.Close()
+
+	it := from.FirstIndexIterator()
+	for {
+		nickname, offsetToSecondIndex, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		offsetsToData, err := from.ReadSecondIndexEntryAt(offsetToSecondIndex)
+		if err != nil {
+			return err
+		}
+		for _, offsetToData := range offsetsToData {
+			if err := sorter.Add(newNickNameRecord(nickname, offsetToData)); err != nil {
+				return err
+			}
+		}
+	}
+	log.Println("Offsets to group:", sorter.GetRecordCount())
+
+	sorted, err := sorter.Sort()
+	if err != nil {
+		return err
+	}
+
+	var nickname string
+	var offsets []uint64
+	writeGroup := func() error {
+		entrys := make([]*DataEntry, len(offsets))
+		for i, offset := range offsets {
+			entry, err := from.ReadDataEntryAt(offset)
+			if err != nil {
+				return err
+			}
+			entrys[i] = entry
+		}
+		if err := to.WriteAll(nickname, entrys); err != nil {
+			return fmt.Errorf("to.WriteAll failed: %w", err)
+		}
+		return nil
+	}
+
+	for {
+		rec, err := sorted.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		recNickName, offsetToData := parseNickNameRecord(rec)
+		if recNickName != nickname && len(offsets) != 0 {
+			if err := writeGroup(); err != nil {
+				return err
+			}
+			offsets = offsets[:0]
+		}
+		nickname = recNickName
+		offsets = append(offsets, offsetToData)
+	}
+	if len(offsets) != 0 {
+		return writeGroup()
+	}
+
+	return nil
+}
+
+// Record used to rebuild the indexes with ExternalSorter: Offset (8 byte) + Time (8 byte) + NickName.
+func newReindexRecord(nickname string, t time.Time, offset uint64) []byte {
+	rec := make([]byte, 16+len(nickname))
+	binary.LittleEndian.PutUint64(rec[:8], offset)
+	binary.LittleEndian.PutUint64(rec[8:16], uint64(t.Unix()))
+	copy(rec[16:], nickname)
+	return rec
+}
+
+func lessReindexRecord(a, b []byte) bool {
+	if c := bytes.Compare(a[16:], b[16:]); c != 0 {
+		return c < 0
+	}
+	return int64(binary.LittleEndian.Uint64(a[8:16])) < int64(binary.LittleEndian.Uint64(b[8:16]))
+}
+
+// Writes sorted first and second indexes using only the nicknames kept in the data file.
+// Offsets of every nickname are sorted by time the same way as in SortSecondIndex.
+func RebuildIndexes(data *DataFile, firstIndexFile string, secondIndexFile string, tmpDirPath string, memoryLimit int) error {
+	var firstIndex FirstIndexFile
+	if _, err := firstIndex.Open(firstIndexFile); err != nil {
+		return err
+	}
+	defer firstIndex.Close()
+	firstIndex.EnableBulkWrite(DefaultBulkWriteBufferSize)
+
+	var secondIndex SecondIndexFile
+	if _, err := secondIndex.Open(secondIndexFile); err != nil {
+		return err
+	}
+	defer secondIndex.Close()
+	secondIndex.EnableBulkWrite(DefaultBulkWriteBufferSize)
+
+	sorter := NewExternalSorter(tmpDirPath, memoryLimit, lessReindexRecord)
+	defer sorter.Close()
+
+	it := data.Iterator()
+	for {
+		offset, nickname, entry, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := sorter.Add(newReindexRecord(nickname, entry.Time, offset)); err != nil {
+			return err
+		}
+	}
+	log.Println("Data entrys:", sorter.GetRecordCount())
+
+	sorted, err := sorter.Sort()
+	if err != nil {
+		return err
+	}
+
+	var nickname string
+	var offsets []uint64
+	writeGroup := func() error {
+		offsetToSecondIndex, err := secondIndex.WriteEntry(offsets)
+		if err != nil {
+			return err
+		}
+		_, err = firstIndex.WriteEntry(nickname, offsetToSecondIndex)
+		return err
+	}
+
+	for {
+		rec, err := sorted.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		recNickName := string(rec[16:])
+		if recNickName != nickname && len(offsets) != 0 {
+			if err := writeGroup(); err != nil {
+				return err
+			}
+			offsets = offsets[:0]
+		}
+		nickname = recNickName
+		offsets = append(offsets, binary.LittleEndian.Uint64(rec[:8]))
+	}
+	if len(offsets) != 0 {
+		if err := writeGroup(); err != nil {
+			return err
+		}
+	}
+	log.Println("Nicknames:", firstIndex.GetEntryCount())
+
+	if err := firstIndex.Sync(); err != nil {
+		return err
+	}
+	return secondIndex.Sync()
+}
+
+type keyIndexBuilder struct {
+	fileName string
+	newFile  func() *KeyIndexFile
+	// Returns nil if the entry should not be indexed.
+	key func(data *DataEntry) ([]byte, error)
+}
+
+var keyIndexBuilders = []keyIndexBuilder{
+	{"ip_index.bin", NewIPIndexFile, func(data *DataEntry) ([]byte, error) { return ipIndexKey(data.IP) }},
+	{"fingerprint_index.bin", NewFingerprintIndexFile, func(data *DataEntry) ([]byte, error) { return fingerprintIndexKey(data.Fingerprint), nil }},
+	{"time_index.bin", NewTimeIndexFile, func(data *DataEntry) ([]byte, error) { return timeIndexKey(data.Time), nil }},
+	{"server_index.bin", NewServerIndexFile, func(data *DataEntry) ([]byte, error) { return serverIndexKey(data.Server, data.Time), nil }},
+}
+
+// Builds every key index of a sorted database in one pass over its data.
+// Records are sorted as Key + NickNameID + DataOffset, all in big endian.
+func BuildKeyIndexes(from *MordorLogsDB, dirPath string, tmpDirPath string, memoryLimit int) error {
+	sorters := make([]*ExternalSorter, len(keyIndexBuilders))
+	for i := range keyIndexBuilders {
+		sorters[i] = NewExternalSorter(tmpDirPath, memoryLimit/len(keyIndexBuilders), func(a, b []byte) bool { return bytes.Compare(a, b) < 0 })
+		defer sorters[i].Close()
 	}
 
+	rec := make([]byte, 0, 64)
+	it := from.FirstIndexIterator()
+	for nicknameID := uint32(0); ; nicknameID++ {
+		_, offsetToSecondIndex, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		offsetsToData, err := from.ReadSecondIndexEntryAt(offsetToSecondIndex)
+		if err != nil {
+			return err
+		}
+		for _, offsetToData := range offsetsToData {
+			data, err := from.ReadDataEntryAt(offsetToData)
+			if err != nil {
+				return err
+			}
+			for i, builder := range keyIndexBuilders {
+				key, err := builder.key(data)
+				if err != nil {
+					return err
+				} else if key == nil { // Not indexed.
+					continue
+				}
+				rec = append(rec[:0], key...)
+				rec = append(rec, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+				binary.BigEndian.PutUint32(rec[len(key):], nicknameID)
+				binary.BigEndian.PutUint64(rec[len(key)+4:], offsetToData)
+				if err := sorters[i].Add(rec); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for i, builder := range keyIndexBuilders {
+		if err := writeKeyIndex(builder.newFile(), filepath.Join(dirPath, builder.fileName), sorters[i]); err != nil {
+			return fmt.Errorf("%s: %w", builder.fileName, err)
+		}
+	}
 	return nil
 }
 
+func writeKeyIndex(index *KeyIndexFile, filePath string, sorter *ExternalSorter) error {
+	isnew, err := index.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+	if !isnew {
+		return ErrCorrupted
+	}
+
+	sorted, err := sorter.Sort()
+	if err != nil {
+		return err
+	}
+	keySize := index.GetKeySize()
+	for {
+		rec, err := sorted.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return err
+		}
+		nicknameID := binary.BigEndian.Uint32(rec[keySize:])
+		dataOffset := binary.BigEndian.Uint64(rec[keySize+4:])
+		if err := index.WriteEntry(rec[:keySize], nicknameID, dataOffset); err != nil {
+			return err
+		}
+	}
+
+	return index.Sync()
+}
+
+// Builds folded_index.bin of a sorted database.
+func BuildFoldedIndex(from *MordorLogsDB, foldedIndexFile string, tmpDirPath string, memoryLimit int) error {
+	var foldedIndex FoldedIndexFile
+	isnew, err := foldedIndex.Open(foldedIndexFile)
+	if err != nil {
+		return err
+	}
+	defer foldedIndex.Close()
+	if !isnew {
+		return ErrCorrupted
+	}
+
+	// Record: NickNameID (4 byte) + folded nickname. Nicknames with the same folded form keep the order of the first index.
+	sorter := NewExternalSorter(tmpDirPath, memoryLimit, func(a, b []byte) bool { return bytes.Compare(a[4:], b[4:]) < 0 })
+	defer sorter.Close()
+
+	it := from.FirstIndexIterator()
+	for nicknameID := uint32(0); ; nicknameID++ {
+		nickname, _, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return err
+		}
+		folded := foldNickName(nickname)
+		rec := make([]byte, 4+len(folded))
+		binary.LittleEndian.PutUint32(rec, nicknameID)
+		copy(rec[4:], folded)
+		if err := sorter.Add(rec); err != nil {
+			return err
+		}
+	}
+
+	sorted, err := sorter.Sort()
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := sorted.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := foldedIndex.WriteEntry(binary.LittleEndian.Uint32(rec)); err != nil {
+			return err
+		}
+	}
+
+	return foldedIndex.Sync()
+}
+
 // Very slow, don't use.
 func SortDatabase(from *MordorLogsDB, to *MordorLogsDB) error {
 	it := from.Iterator()
@@ -131,11 +525,10 @@ func MemSortDatabase(from *MordorLogsDB, to *MordorLogsDB) error {
 	if err := it.ReadToMemory(); err != nil {
 		return err
 	}
-	fmt.Println("FirstIndex read into memory.")
 	data := it.GetMap()
+	log.Println("FirstIndex read into memory, nicknames:", len(data))
 	for nickname, offsets := range data {
 		offsetsCount := len(offsets)
-		fmt.Println(nickname, offsetsCount)
 		entrys := make([]*DataEntry, offsetsCount)
 
 		for i, offset := range offsets {
@@ -153,64 +546,129 @@ func MemSortDatabase(from *MordorLogsDB, to *MordorLogsDB) error {
 	return nil
 }
 
-func ConvertLogsToDatabase(dirPath string, mldb *MordorLogsDB) error {
-	return parseDateDirLogs(filepath.Join(dirPath, "client_log"), mldb)
+// Log files are parsed by workers goroutines, but written to the database in the same order
+// as by a sequential walk over the date directories, so the result doesn't depend on workers.
+func ConvertLogsToDatabase(dirPath string, mldb *MordorLogsDB, workers int) error {
+	files, days, err := listLogFiles(filepath.Join(dirPath, "client_log"))
+	if err != nil {
+		return err
+	}
+	writeLogFiles(files, days, mldb, workers)
+	return nil
+}
+
+type logFile struct {
+	path     string
+	nickname string
+	day      int // Number of the date directory.
 }
 
-func parseDateDirLogs(dirPath string, mldb *MordorLogsDB) error {
+// Returns log files of all date directories in lexical order and names of the directories.
+func listLogFiles(dirPath string) ([]logFile, []string, error) {
 	dateDirs, err := ioutil.ReadDir(dirPath)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	for _, file := range dateDirs {
-		if file.IsDir() {
-			if err := parseDirLogs(filepath.Join(dirPath, file.Name()), mldb); err != nil {
-				log.Println("parseDirLogs:", err)
+	files := make([]logFile, 0)
+	days := make([]string, 0)
+	for _, dir := range dateDirs {
+		if !dir.IsDir() {
+			continue
+		}
+		day := len(days)
+		days = append(days, dir.Name())
+		err := filepath.Walk(filepath.Join(dirPath, dir.Name()), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
 			}
+			if !info.IsDir() && filepath.Ext(info.Name()) == ".log" {
+				nickName := info.Name()
+				nickName = nickName[:len(nickName)-4] // len(".log") == 4
+				if nickName != "today" {
+					files = append(files, logFile{path, nickName, day})
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("%s: %v", dir.Name(), err)
 		}
 	}
-	return nil
+	return files, days, nil
 }
 
-func parseDirLogs(dirPath string, mldb *MordorLogsDB) error {
-	return filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+type parsedLogFile struct {
+	entrys []DataEntry
+	err    error
+}
 
-		if !info.IsDir() && filepath.Ext(info.Name()) == ".log" {
-			nickName := info.Name()
-			nickName = nickName[:len(nickName)-4] // len(".log") == 4
-			if nickName == "today" {
-				return nil
-			}
-			items, err := ParseLogFile(path)
-			if err != nil {
-				return fmt.Errorf("ParseLogFile failed: %w", err)
+type parseLogJob struct {
+	path   string
+	result chan parsedLogFile
+}
+
+// How many parsed files may wait for the writer per worker.
+const parsedLogFilesPerWorker = 4
+
+// A failed file skips the rest of its date directory, the error is only logged.
+func writeLogFiles(files []logFile, days []string, mldb *MordorLogsDB, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan parseLogJob)
+	// Results in the order of files, the capacity limits how far the workers may run ahead of the writer.
+	results := make(chan chan parsedLogFile, workers*parsedLogFilesPerWorker)
+	go func() {
+		for _, file := range files {
+			result := make(chan parsedLogFile, 1)
+			results <- result
+			jobs <- parseLogJob{file.path, result}
+		}
+		close(jobs)
+		close(results)
+	}()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range jobs {
+				entrys, err := ParseLogFile(job.path)
+				job.result <- parsedLogFile{entrys, err}
 			}
+		}()
+	}
 
-			for _, data := range items {
-				if err := mldb.Write(nickName, data); err != nil {
-					return fmt.Errorf("db.Write failed: %w", err)
-				}
+	day, failedDay := -1, -1
+	i := 0
+	for result := range results {
+		parsed := <-result
+		file := files[i]
+		i++
+
+		if file.day != day {
+			day = file.day
+			log.Printf("Parsing %s (%d/%d)...", days[day], day+1, len(days))
+		}
+		if day == failedDay {
+			continue
+		}
 
-				/*
-					fmt.Println("")
-					fmt.Println("NickName:", nickName)
-					fmt.Println("Time:", v.Time.Format("02.01.2006 15:04:05"))
-					fmt.Println("Android:", v.Android)
-					fmt.Println("Brand:", v.Brand)
-					fmt.Println("Model:", v.Model)
-					fmt.Println("Fingerprint:", v.Fingerprint)
-					fmt.Println("IP:", v.IP)
-					fmt.Println("Server:", v.Server)
-					fmt.Println("")
-				*/
+		err := parsed.err
+		if err != nil {
+			err = fmt.Errorf("ParseLogFile failed: %w", err)
+		} else {
+			for _, data := range parsed.entrys {
+				if err = mldb.Write(file.nickname, data); err != nil {
+					err = fmt.Errorf("db.Write failed: %w", err)
+					break
+				}
 			}
 		}
-		return nil
-	})
+		if err != nil {
+			log.Printf("%s: %v", days[day], err)
+			failedDay = day
+		}
+	}
 }
 
 func ParseLogFile(filePath string) ([]DataEntry, error) {
@@ -284,12 +742,14 @@ func ParseLogFile(filePath string) ([]DataEntry, error) {
 		//if !bytes.Equal(line[:3], []byte("IP:")) {
 		//	continue
 		//}
+		// IPv6 addresses may be written in brackets: IP: [2001:db8::1]
 		separatorIndex = bytes.IndexRune(line, '|')
-		e.IP = net.ParseIP(string(line[4 : separatorIndex-1]))
+		e.IP = net.ParseIP(strings.Trim(string(line[4:separatorIndex-1]), "[]"))
 		if e.IP == nil {
 			log.Println("Failed parse IP address:", string(line[4:separatorIndex-1]))
 			continue
 		}
+		e.IP = dataEntryIP(e.IP)
 
 		// Server: 192.168.1.1:7777
 		line = line[separatorIndex+2:]
@@ -0,0 +1,100 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteLogFiles(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "mordorlogs-test-parse-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	// Files of different sizes, so the workers finish them out of order.
+	logs := testLogs{}
+	for day := 1; day <= 3; day++ {
+		date := fmt.Sprintf("%02d.08.2020", day)
+		logs[date] = make(map[string][]string)
+		for n := 0; n < 20; n++ {
+			lines := make([]string, 1+(n*7+day)%13)
+			for i := range lines {
+				lines[i] = testLogLine(fmt.Sprintf("%s %02d:%02d:%02d", date, n, i, day), fmt.Sprintf("10.%d.%d.%d", day, n, i), "fp", "1.1.1.1:7777")
+			}
+			logs[date][fmt.Sprintf("Nick_%02d", n)] = lines
+		}
+	}
+	logsPath := filepath.Join(dirPath, "logs")
+	writeTestLogs(t, logsPath, logs)
+	files, days, err := listLogFiles(filepath.Join(logsPath, "client_log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(days, []string{"01.08.2020", "02.08.2020", "03.08.2020"}) {
+		t.Fatalf("days %q", days)
+	}
+
+	// Entrys in the order of the files and their lines.
+	want := make([]string, 0)
+	for _, file := range files {
+		for _, line := range logs[days[file.day]][file.nickname] {
+			want = append(want, file.nickname+" "+line[4:23])
+		}
+	}
+
+	for _, workers := range []int{0, 1, 3, 16} {
+		mldb, err := createDatabase(filepath.Join(dirPath, fmt.Sprintf("db%d", workers)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeLogFiles(files, days, mldb, workers)
+		if err := mldb.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		got := make([]string, 0)
+		it := mldb.FirstIndexIterator()
+		for {
+			nickname, offsetToSecondIndex, err := it.Next()
+			if err == ErrIterationDone {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			entrys, err := mldb.readDataBySecondIndex(offsetToSecondIndex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, data := range entrys {
+				got = append(got, nickname+" "+data.Time.UTC().Format(timeFormatLayout))
+			}
+		}
+		mldb.Close()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d workers: entrys are written in a different order", workers)
+		}
+	}
+}
@@ -19,32 +19,76 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"net"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
 const TG_BOT_API = ""
 
-const helpMessage = "Привет, отправь мне ник игрока с Mordor RP.\nНикнейм может включать только следующие символы: `a-z`, `A-Z`, `0-9`, `[]`, `()`, `$`, `@`, `.`, `_`, `=`, а длина должна быть не менее 3 символов и не более 24."
+const helpMessage = "Привет, отправь мне ник игрока с Mordor RP.\nНикнейм может включать любые символы, кроме пробелов, `/`, `*` и `?`, а длина должна быть не менее 3 символов и не более 64224 байт.\n\nПоиск ников по IP адресу: `ip 1.2.3.4` или `ip 2001:db8::1`, по подсети: `ip 10.0.0.0/16`.\nЗаписи за период: `Nick_Name 01.08.2020-15.08.2020`.\nВозможные твинки игрока: `/alts Nick_Name`.\nКто заходил за период: `/time 01.08.2020 21:00-21:30`.\nПоиск по шаблону: `[ABC]_*` (`*` — любые символы, `?` — один символ) или по регулярному выражению: `/re ^Nick_\\d+$`.\nИгроки сервера за период: `/server 1.2.3.4:7777 01.08.2020-31.08.2020`.\nПоиск записей, ников по IP и `/time` можно ограничить одним сервером, добавив в конец `@1.2.3.4:7777`.\nПоиск по условиям: `/q ip=1.2.3.0/24 AND brand=Xiaomi AND time>=01.08.2020`."
+
+const maxNickNamesInMessage = 50
+
+const maxSuggestions = 20
 
 const timeFormatLayout = "02.01.2006 15:04:05"
 
-var validNickName = regexp.MustCompile(`^[a-zA-Z0-9\[\]\(\)\$@\._=]{3,24}$`)
+const dateFormatLayout = "02.01.2006"
+
+// Nicknames are taken from the names of the log files, so they can have any characters except the path
+// separator; spaces split the message and '*' and '?' make it a pattern.
+var validNickName = regexp.MustCompile(`^[^\s/\*\?]{3,}$`)
+
+// The length is checked apart from the regexp, since MaxNickNameLength is in bytes and too large for a repeat count.
+func isValidNickName(nickname string) bool {
+	return len(nickname) <= MaxNickNameLength && validNickName.MatchString(nickname)
+}
+
+var validNickNamePattern = regexp.MustCompile(`^[^\s/]+$`)
+
+func isNickNamePattern(text string) bool {
+	return len(text) <= MaxNickNameLength && strings.ContainsAny(text, "*?") && validNickNamePattern.MatchString(text)
+}
+
+const searchTimeout = 2 * time.Second
+
+const maxPatternLength = 100
+
+// Messages are mostly waiting for Telegram, so there are more workers than CPUs.
+const DefaultBotWorkers = 16
+
+const botQueueSize = 64
 
 var (
-	bot  *tgbotapi.BotAPI
-	mldb *MordorLogsDB
+	bot             *tgbotapi.BotAPI
+	mldb            *MordorLogsReader
+	nicknameMatcher *NickNameMatcher // Read-only after start, so it is safe for concurrent use too.
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil && err != flag.ErrHelp {
+			log.Fatalln(err)
+		}
+		return
+	}
+	runBot("./mordor.db", true, DefaultBotWorkers)
+}
+
+func runBot(dbPath string, mmap bool, workers int) {
 	var err error
 
-	mldb, _, err = NewMordorLogsDB("./mordor.db")
+	mldb, err = OpenMordorLogsReader(dbPath, mmap)
 	if err != nil {
 		log.Panicln(err)
 	}
@@ -52,6 +96,11 @@ func main() {
 
 	fmt.Println("Number of nicknames:", mldb.GetEntryCount())
 
+	nicknameMatcher, err = NewNickNameMatcher(mldb.FirstIndexIterator())
+	if err != nil {
+		log.Panicln(err)
+	}
+
 	bot, err = tgbotapi.NewBotAPI(TG_BOT_API)
 	if err != nil {
 		log.Panicln(err)
@@ -66,18 +115,47 @@ func main() {
 		log.Panicln(err)
 	}
 
+	dispatchUpdates(updates, workers, handleMessage)
+}
+
+// Messages of one chat are handled by the same worker in the order they came,
+// messages of different chats are handled concurrently by at most workers goroutines.
+func dispatchUpdates(updates tgbotapi.UpdatesChannel, workers int, handle func(msg *tgbotapi.Message)) {
+	if workers < 1 {
+		workers = 1
+	}
+	queues := make([]chan *tgbotapi.Message, workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan *tgbotapi.Message, botQueueSize)
+		wg.Add(1)
+		go func(queue chan *tgbotapi.Message) {
+			defer wg.Done()
+			for msg := range queue {
+				handle(msg)
+			}
+		}(queues[i])
+	}
+
 	for update := range updates {
 		if update.Message != nil {
-			handleMessage(update.Message)
+			queues[uint64(update.Message.Chat.ID)%uint64(workers)] <- update.Message
 		}
 	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
 }
 
 func handleFindDataErrors(err error) string {
 	if err == ErrLongNickName {
-		return "Максимальная длина ника 24 символа."
+		return fmt.Sprintf("Максимальная длина ника %d байт.", MaxNickNameLength)
 	} else if err == ErrEntryNotFound {
 		return "Игрок с данным ником не найден в базе."
+	} else if err == ErrIndexNotFound {
+		return "Этот вид поиска недоступен для текущей базы."
 	} else if err != nil {
 		log.Println(err)
 		return "Произошла внутренняя ошибка, сообщите об этом создателю бота."
@@ -94,6 +172,10 @@ func sendMarkDownMessage(chatId int64, message string) {
 }
 
 func formatLogData(nickname string, data *DataEntry) string {
+	return formatDataEntry(nickname, data) + formatDeviceNickNames(nickname, data)
+}
+
+func formatDataEntry(nickname string, data *DataEntry) string {
 	return fmt.Sprintf("*NickName:* %s\n*Time:* %s\n*IP:* `%s`\n*Android:* %s\n*Brand:* %s\n*Model:* %s\n*Fingerprint:* %s\n*Server:* `%s`",
 		tgbotapi.EscapeText(tgbotapi.ModeMarkdown, nickname),
 		tgbotapi.EscapeText(tgbotapi.ModeMarkdown, data.Time.Format(timeFormatLayout)),
@@ -105,38 +187,530 @@ func formatLogData(nickname string, data *DataEntry) string {
 		tgbotapi.EscapeText(tgbotapi.ModeMarkdown, data.Server))
 }
 
+// Other accounts that were used on the same device.
+func formatDeviceNickNames(nickname string, data *DataEntry) string {
+	nicknames, err := mldb.FindNickNamesByFingerprint(data.Fingerprint)
+	if err != nil {
+		if err != ErrEntryNotFound && err != ErrIndexNotFound {
+			log.Println(err)
+		}
+		return ""
+	}
+
+	others := make([]string, 0, len(nicknames))
+	for _, other := range nicknames {
+		if other != nickname {
+			others = append(others, other)
+		}
+	}
+	if len(others) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n\n*Другие аккаунты на этом устройстве (%d):*\n", len(others)) + formatNickNames(others)
+}
+
+func formatNickNames(nicknames []string) string {
+	output := ""
+	for i, nickname := range nicknames {
+		if i == maxNickNamesInMessage {
+			output += fmt.Sprintf("... и ещё %d.\n", len(nicknames)-maxNickNamesInMessage)
+			break
+		}
+		output += fmt.Sprintf("`%s`\n", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, nickname))
+	}
+	return output
+}
+
+func handleIPMessage(msg *tgbotapi.Message, text string, server string) {
+	if strings.Contains(text, "/") {
+		handleIPRangeMessage(msg, text, server)
+		return
+	}
+
+	ip := net.ParseIP(strings.Trim(text, "[]"))
+	if ip == nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Необходимо вводить IPv4 или IPv6 адрес, например: ip 1.2.3.4 или ip 2001:db8::1"))
+		return
+	}
+
+	nicknames, err := mldb.FindNickNamesByIPOnServer(ip, server)
+	if err == ErrEntryNotFound {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Игроки с данным IP адресом не найдены в базе."))
+		return
+	} else if errmsg := handleFindDataErrors(err); errmsg != "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, errmsg))
+		return
+	}
+
+	output := fmt.Sprintf("Найдено %d ников с IP `%s`:\n\n", len(nicknames), ip.String())
+	output += formatNickNames(nicknames)
+	sendMarkDownMessage(msg.Chat.ID, output)
+}
+
+func handleIPRangeMessage(msg *tgbotapi.Message, text string, server string) {
+	_, subnet, err := net.ParseCIDR(text)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Необходимо вводить IPv4 или IPv6 подсеть, например: ip 10.0.0.0/16 или ip 2001:db8::/32"))
+		return
+	}
+
+	it, err := mldb.FindByIPRange(*subnet)
+	if errmsg := handleFindDataErrors(err); errmsg != "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, errmsg))
+		return
+	}
+	if server != "" {
+		it.FilterByServer(server)
+	}
+
+	output, count, more, err := formatRangeNickNames(it, func(nickname string, data *DataEntry) string {
+		return fmt.Sprintf("`%s` — `%s`\n", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, nickname), tgbotapi.EscapeText(tgbotapi.ModeMarkdown, data.IP.String()))
+	})
+	if errmsg := handleFindDataErrors(err); errmsg != "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, errmsg))
+		return
+	}
+
+	if count == 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Игроки из данной подсети не найдены в базе."))
+		return
+	}
+	if more {
+		output = fmt.Sprintf("В подсети `%s` найдено больше %d ников, показаны первые:\n\n", subnet.String(), maxNickNamesInMessage) + output
+	} else {
+		output = fmt.Sprintf("Найдено %d ников в подсети `%s`:\n\n", count, subnet.String()) + output
+	}
+	sendMarkDownMessage(msg.Chat.ID, output)
+}
+
+// Formats the first entry of every nickname. Stops as soon as the message is full,
+// a large range may contain the whole database.
+func formatRangeNickNames(it EntryIterator, format func(nickname string, data *DataEntry) string) (output string, count int, more bool, err error) {
+	usedNickNames := make(map[string]bool)
+	for {
+		nickname, data, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return "", 0, false, err
+		}
+		if usedNickNames[nickname] {
+			continue
+		}
+		if len(usedNickNames) == maxNickNamesInMessage {
+			more = true
+			break
+		}
+		usedNickNames[nickname] = true
+		output += format(nickname, data)
+	}
+	return output, len(usedNickNames), more, nil
+}
+
+func handleTimeRangeMessage(msg *tgbotapi.Message, text string, server string) {
+	from, to, err := parseTimeRange(text)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Необходимо вводить период в формате 01.08.2020 21:00-21:30 или 01.08.2020-02.08.2020."))
+		return
+	}
+
+	var it *KeyRangeIterator
+	if server != "" {
+		it, err = mldb.FindByServer(server, from, to)
+	} else {
+		it, err = mldb.FindByTimeRange(from, to)
+	}
+	if errmsg := handleFindDataErrors(err); errmsg != "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, errmsg))
+		return
+	}
+	output, count, more, err := formatRangeNickNames(it, func(nickname string, data *DataEntry) string {
+		return fmt.Sprintf("%s `%s`\n", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, data.Time.Format(timeFormatLayout)), tgbotapi.EscapeText(tgbotapi.ModeMarkdown, nickname))
+	})
+	if errmsg := handleFindDataErrors(err); errmsg != "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, errmsg))
+		return
+	}
+
+	if count == 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "За данный период никто не заходил."))
+		return
+	}
+	if more {
+		output = fmt.Sprintf("За период зашло больше %d ников, показаны первые:\n\n", maxNickNamesInMessage) + output
+	} else {
+		output = fmt.Sprintf("За период зашло %d ников:\n\n", count) + output
+	}
+	sendMarkDownMessage(msg.Chat.ID, output)
+}
+
+func handleServerMessage(msg *tgbotapi.Message, text string) {
+	parts := strings.SplitN(text, " ", 2)
+	if len(parts) != 2 || !validServer(parts[0]) {
+		sendMarkDownMessage(msg.Chat.ID, "Необходимо вводить адрес сервера и период, например: `/server 1.2.3.4:7777 01.08.2020-31.08.2020`.")
+		return
+	}
+	handleTimeRangeMessage(msg, parts[1], parts[0])
+}
+
+// Server addresses are written to the logs as "ip:port".
+func validServer(server string) bool {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil || net.ParseIP(host) == nil {
+		return false
+	}
+	_, err = strconv.ParseUint(port, 10, 16)
+	return err == nil
+}
+
+// Cuts off a trailing " @ip:port" server filter from the message.
+func splitServerFilter(text string) (string, string) {
+	i := strings.LastIndex(text, " @")
+	if i == -1 || !validServer(text[i+2:]) {
+		return text, ""
+	}
+	return strings.TrimSpace(text[:i]), text[i+2:]
+}
+
+// Returns the reply for commands that search across all servers and cannot take the @server filter, or "".
+func unsupportedServerFilter(text string) string {
+	if strings.HasPrefix(text, "/alts ") {
+		return "Связанные аккаунты ищутся по всем серверам, фильтр @сервер здесь не поддерживается."
+	}
+	if strings.HasPrefix(text, "/re ") || isNickNamePattern(text) {
+		return "Поиск ников по шаблону не поддерживает фильтр @сервер."
+	}
+	return ""
+}
+
+// Returns numbers of the entrys in the list of all entrys of the nickname, starting from first.
+// Entrys from other servers are dropped when server is set.
+func filterEntrysByServer(entrys []*DataEntry, first int, server string) ([]*DataEntry, []int) {
+	ids := make([]int, len(entrys))
+	for i := range ids {
+		ids[i] = i
+	}
+	if server != "" {
+		entrys, ids = FilterDataByServer(entrys, server)
+	}
+	for i := range ids {
+		ids[i] += first + 1
+	}
+	return entrys, ids
+}
+
+func handleQueryMessage(msg *tgbotapi.Message, expr string) {
+	q, err := ParseQuery(expr)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Неверный запрос: "+err.Error()))
+		return
+	}
+	it, err := mldb.RunQuery(q)
+	if errmsg := handleFindDataErrors(err); errmsg != "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, errmsg))
+		return
+	}
+
+	// A full scan of a large database may take long, so stop after a while.
+	it.SetDeadline(time.Now().Add(searchTimeout))
+	limited := &timeoutEntryIterator{it: it}
+	output, count, more, err := formatRangeNickNames(limited, func(nickname string, data *DataEntry) string {
+		return fmt.Sprintf("%s `%s`\n", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, data.Time.Format(timeFormatLayout)), tgbotapi.EscapeText(tgbotapi.ModeMarkdown, nickname))
+	})
+	timedOut := limited.timedOut
+	if errmsg := handleFindDataErrors(err); errmsg != "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, errmsg))
+		return
+	}
+
+	if count == 0 && timedOut {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Поиск занял слишком много времени, уточните запрос."))
+		return
+	}
+	if count == 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "По запросу ничего не найдено."))
+		return
+	}
+	if more || timedOut {
+		output = fmt.Sprintf("Найдено не меньше %d ников, показаны первые:\n\n", count) + output
+	} else {
+		output = fmt.Sprintf("Найдено %d ников:\n\n", count) + output
+	}
+	sendMarkDownMessage(msg.Chat.ID, output)
+}
+
+// Ends the iteration when the query has timed out, so the entrys found before are still shown.
+type timeoutEntryIterator struct {
+	it       EntryIterator
+	timedOut bool
+}
+
+func (m *timeoutEntryIterator) Next() (string, *DataEntry, error) {
+	nickname, data, err := m.it.Next()
+	if err == ErrQueryTimeout {
+		m.timedOut = true
+		return "", nil, ErrIterationDone
+	}
+	return nickname, data, err
+}
+
+func handleClusterMessage(msg *tgbotapi.Message, nickname string) {
+	if !isValidNickName(nickname) {
+		sendMarkDownMessage(msg.Chat.ID, helpMessage)
+		return
+	}
+
+	nicknames, links, err := mldb.FindCluster(nickname)
+	if err == ErrNoCluster {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Связанные аккаунты не найдены."))
+		return
+	} else if errmsg := handleFindDataErrors(err); errmsg != "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, errmsg))
+		return
+	}
+
+	output := fmt.Sprintf("Группа из %d связанных аккаунтов:\n\n", len(nicknames))
+	output += formatNickNames(nicknames)
+	output += "\n*Связаны через:*\n"
+	for i, link := range links {
+		if i == maxNickNamesInMessage {
+			output += fmt.Sprintf("... и ещё %d.\n", len(links)-maxNickNamesInMessage)
+			break
+		}
+		output += fmt.Sprintf("%s `%s`: %s\n", link.Kind, tgbotapi.EscapeText(tgbotapi.ModeMarkdown, link.Value),
+			tgbotapi.EscapeText(tgbotapi.ModeMarkdown, strings.Join(link.NickNames, ", ")))
+	}
+	sendMarkDownMessage(msg.Chat.ID, output)
+}
+
+// Nicknames with typos first, then nicknames starting with the given one.
+// Case is ignored when the database allows it.
+func findSimilarNickNames(nickname string) []string {
+	// Allow one typo per four characters.
+	maxDistance := minInt(len(nickname)/4+1, 3)
+	nicknames := nicknameMatcher.Find(nickname, maxDistance, maxSuggestions)
+
+	prefixed, err := mldb.FindByNickNamePrefixFold(nickname, maxSuggestions)
+	if err == ErrIndexNotFound {
+		prefixed, err = mldb.FindByNickNamePrefix(nickname, maxSuggestions)
+	}
+	if err != nil && err != ErrEntryNotFound {
+		log.Println(err)
+	}
+
+	for _, other := range prefixed {
+		if len(nicknames) == maxSuggestions {
+			break
+		}
+		found := false
+		for _, used := range nicknames {
+			if used == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			nicknames = append(nicknames, other)
+		}
+	}
+	return nicknames
+}
+
+func handleSearchMessage(msg *tgbotapi.Message, pattern string) {
+	if len(pattern) > maxPatternLength {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Максимальная длина шаблона %d символов.", maxPatternLength)))
+		return
+	}
+	compiled, err := CompileNickNamePattern(pattern)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Неверный шаблон: "+err.Error()))
+		return
+	}
+
+	nicknames, truncated, err := mldb.SearchNickNames(compiled, maxNickNamesInMessage, searchTimeout)
+	if errmsg := handleFindDataErrors(err); errmsg != "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, errmsg))
+		return
+	}
+	if len(nicknames) == 0 {
+		if truncated {
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Поиск занял слишком много времени, уточните шаблон."))
+		} else {
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Подходящие ники не найдены в базе."))
+		}
+		return
+	}
+
+	output := fmt.Sprintf("Найдено %d ников:\n\n", len(nicknames))
+	if truncated {
+		output = fmt.Sprintf("Показаны первые %d найденных ников, уточните шаблон:\n\n", len(nicknames))
+	}
+	output += formatNickNames(nicknames)
+	sendMarkDownMessage(msg.Chat.ID, output)
+}
+
+// Parses "01.08.2020-15.08.2020" or "01.08.2020", both days are included.
+// Log times are parsed as UTC, so dates are too.
+func parseDateRange(text string) (time.Time, time.Time, error) {
+	parts := strings.SplitN(text, "-", 2)
+	from, err := time.ParseInLocation(dateFormatLayout, parts[0], time.UTC)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to := from
+	if len(parts) == 2 {
+		if to, err = time.ParseInLocation(dateFormatLayout, parts[1], time.UTC); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid date range %s", text)
+	}
+	return from, to.Add(24*time.Hour - time.Second), nil
+}
+
+var timeOfDayRange = regexp.MustCompile(`^(\d\d\.\d\d\.\d{4}) (\d\d:\d\d)-(\d\d:\d\d)$`)
+
+// Parses "01.08.2020 21:00-21:30" (minutes are included) or the formats of parseDateRange.
+func parseTimeRange(text string) (time.Time, time.Time, error) {
+	match := timeOfDayRange.FindStringSubmatch(text)
+	if match == nil {
+		return parseDateRange(text)
+	}
+	from, err := time.ParseInLocation(dateFormatLayout+" 15:04", match[1]+" "+match[2], time.UTC)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := time.ParseInLocation(dateFormatLayout+" 15:04", match[1]+" "+match[3], time.UTC)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid time range %s", text)
+	}
+	return from, to.Add(time.Minute - time.Second), nil
+}
+
+func handleDateRangeMessage(msg *tgbotapi.Message, nickname string, dateRange string, server string) {
+	if !isValidNickName(nickname) {
+		sendMarkDownMessage(msg.Chat.ID, helpMessage)
+		return
+	}
+	from, to, err := parseDateRange(dateRange)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Необходимо вводить период в формате 01.08.2020-15.08.2020."))
+		return
+	}
+
+	entrys, first, err := mldb.FindDataByNickNameBetween(nickname, from, to)
+	if errmsg := handleFindDataErrors(err); errmsg != "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, errmsg))
+		return
+	}
+	entrys, ids := filterEntrysByServer(entrys, first, server)
+	entrysCount := len(entrys)
+
+	if entrysCount == 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "За данный период записей не найдено."))
+	} else if entrysCount == 1 {
+		sendMarkDownMessage(msg.Chat.ID, formatLogData(nickname, entrys[0]))
+	} else if entrysCount <= 20 {
+		// IDs are the same as in the list of all entrys, so "Nick id" works.
+		output := fmt.Sprintf("За период найдено %d записей:\n\n", entrysCount)
+		for i, data := range entrys {
+			output += fmt.Sprintf("%d: %s\n", ids[i], data.Time.Format(timeFormatLayout))
+		}
+		output += fmt.Sprintf("\nВведите `%s id`.", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, nickname))
+
+		sendMarkDownMessage(msg.Chat.ID, output)
+	} else {
+		sendMarkDownMessage(msg.Chat.ID, fmt.Sprintf("За период найдено %d записей (ID с %d по %d). Введите `%s ID записи` или уменьшите период.",
+			entrysCount, ids[0], ids[entrysCount-1], tgbotapi.EscapeText(tgbotapi.ModeMarkdown, nickname)))
+	}
+}
+
 func handleMessage(msg *tgbotapi.Message) {
-	splitText := strings.Split(msg.Text, " ")
+	if strings.HasPrefix(msg.Text, "/server ") {
+		handleServerMessage(msg, strings.TrimSpace(msg.Text[8:]))
+		return
+	}
+	if strings.HasPrefix(msg.Text, "/q ") {
+		handleQueryMessage(msg, strings.TrimSpace(msg.Text[3:]))
+		return
+	}
+	text, server := splitServerFilter(msg.Text)
+	if server != "" {
+		if errmsg := unsupportedServerFilter(text); errmsg != "" {
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, errmsg))
+			return
+		}
+	}
+
+	if strings.HasPrefix(text, "ip ") {
+		handleIPMessage(msg, strings.TrimSpace(text[3:]), server)
+		return
+	}
+	if strings.HasPrefix(text, "/alts ") {
+		handleClusterMessage(msg, strings.TrimSpace(text[6:]))
+		return
+	}
+	if strings.HasPrefix(text, "/time ") {
+		handleTimeRangeMessage(msg, strings.TrimSpace(text[6:]), server)
+		return
+	}
+	if strings.HasPrefix(text, "/re ") {
+		handleSearchMessage(msg, regexpPatternPrefix+strings.TrimSpace(text[4:]))
+		return
+	}
+	if isNickNamePattern(text) {
+		handleSearchMessage(msg, text)
+		return
+	}
+
+	splitText := strings.Split(text, " ")
 	splitCount := len(splitText)
 	if splitCount == 1 {
 		nickname := splitText[0]
-		if !validNickName.MatchString(nickname) {
+		if !isValidNickName(nickname) {
 			sendMarkDownMessage(msg.Chat.ID, helpMessage)
 			return
 		}
 
 		entrys, err := mldb.FindDataByNickName(nickname)
+		if err == ErrEntryNotFound {
+			if suggestions := findSimilarNickNames(nickname); len(suggestions) != 0 {
+				sendMarkDownMessage(msg.Chat.ID, "Игрок с данным ником не найден в базе. Возможно, вы искали:\n\n"+formatNickNames(suggestions))
+				return
+			}
+		}
 		if errmsg := handleFindDataErrors(err); errmsg != "" {
 			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, errmsg))
 			return
 		}
+		entrys, ids := filterEntrysByServer(entrys, 0, server)
 		entrysCount := len(entrys)
 
-		if entrysCount == 1 {
+		if entrysCount == 0 {
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "На данном сервере записей не найдено."))
+		} else if entrysCount == 1 {
 			sendMarkDownMessage(msg.Chat.ID, formatLogData(nickname, entrys[0]))
 		} else if entrysCount <= 20 {
 			output := fmt.Sprintf("Найдено %d записей:\n\n", entrysCount)
 			for i, data := range entrys {
-				output += fmt.Sprintf("%d: %s\n", i+1, data.Time.Format(timeFormatLayout))
+				output += fmt.Sprintf("%d: %s\n", ids[i], data.Time.Format(timeFormatLayout))
 			}
-			output += fmt.Sprintf("\nВведите `%s id`.", nickname)
+			output += fmt.Sprintf("\nВведите `%s id`.", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, nickname))
 
 			sendMarkDownMessage(msg.Chat.ID, output)
 		} else {
-			sendMarkDownMessage(msg.Chat.ID, fmt.Sprintf("Найдено %d записей. Введите `%s ID записи`.", entrysCount, nickname))
+			sendMarkDownMessage(msg.Chat.ID, fmt.Sprintf("Найдено %d записей. Введите `%s ID записи`.", entrysCount, tgbotapi.EscapeText(tgbotapi.ModeMarkdown, nickname)))
 		}
 	} else if splitCount == 2 {
 		nickname := splitText[0]
+		if strings.Contains(splitText[1], ".") {
+			handleDateRangeMessage(msg, nickname, splitText[1], server)
+			return
+		}
 		id, err := strconv.ParseUint(splitText[1], 10, 32)
 		if err != nil || id == 0 {
 			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Необходимо вводить положительный целочисленный ID записи."))
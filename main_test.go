@@ -0,0 +1,227 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestParseDateRange(t *testing.T) {
+	tests := []struct {
+		text     string
+		from, to string
+		ok       bool
+	}{
+		{"01.08.2020", "01.08.2020 00:00:00", "01.08.2020 23:59:59", true},
+		{"01.08.2020-15.08.2020", "01.08.2020 00:00:00", "15.08.2020 23:59:59", true},
+		{"31.12.2020-01.01.2021", "31.12.2020 00:00:00", "01.01.2021 23:59:59", true},
+		{"15.08.2020-01.08.2020", "", "", false},
+		{"01.08.2020-", "", "", false},
+		{"2020-08-01", "", "", false},
+		{"32.08.2020", "", "", false},
+	}
+	for _, test := range tests {
+		from, to, err := parseDateRange(test.text)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: error %v", test.text, err)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if got := from.Format(timeFormatLayout); got != test.from {
+			t.Errorf("%q: from %s, want %s", test.text, got, test.from)
+		}
+		if got := to.Format(timeFormatLayout); got != test.to {
+			t.Errorf("%q: to %s, want %s", test.text, got, test.to)
+		}
+		if from.Location() != time.UTC || to.Location() != time.UTC {
+			t.Errorf("%q: times are not in UTC", test.text)
+		}
+	}
+}
+
+func TestSplitServerFilter(t *testing.T) {
+	tests := []struct {
+		text, want, server string
+	}{
+		{"Mike_Tyson", "Mike_Tyson", ""},
+		{"Mike_Tyson @1.1.1.1:7777", "Mike_Tyson", "1.1.1.1:7777"},
+		{"ip 10.0.0.0/16 @1.1.1.1:7777", "ip 10.0.0.0/16", "1.1.1.1:7777"},
+		{"/time 01.08.2020 21:00-21:30  @[2001:db8::1]:7777", "/time 01.08.2020 21:00-21:30", "[2001:db8::1]:7777"},
+		{"Mike_Tyson @1.1.1.1", "Mike_Tyson @1.1.1.1", ""},
+		{"Mike_Tyson @server:7777", "Mike_Tyson @server:7777", ""},
+		{"Mike_Tyson @1.1.1.1:70000", "Mike_Tyson @1.1.1.1:70000", ""},
+		{"@1.1.1.1:7777", "@1.1.1.1:7777", ""},
+	}
+	for _, test := range tests {
+		text, server := splitServerFilter(test.text)
+		if text != test.want || server != test.server {
+			t.Errorf("%q: got %q, %q, want %q, %q", test.text, text, server, test.want, test.server)
+		}
+	}
+}
+
+func TestUnsupportedServerFilter(t *testing.T) {
+	tests := []struct {
+		text        string
+		unsupported bool
+	}{
+		{"Mike_Tyson", false},
+		{"Mike_Tyson 01.08.2020-15.08.2020", false},
+		{"ip 10.0.0.0/16", false},
+		{"/time 01.08.2020 21:00-21:30", false},
+		{"/alts Mike_Tyson", true},
+		{"/re ^Mike_", true},
+		{"[ABC]_*", true},
+		{"Mike_Tyso?", true},
+	}
+	for _, test := range tests {
+		if got := unsupportedServerFilter(test.text) != ""; got != test.unsupported {
+			t.Errorf("%q: got unsupported %v, want %v", test.text, got, test.unsupported)
+		}
+	}
+}
+
+func TestFilterEntrysByServer(t *testing.T) {
+	entrys := []*DataEntry{{Server: "1.1.1.1:7777"}, {Server: "2.2.2.2:7777"}, {Server: "1.1.1.1:7777"}}
+	tests := []struct {
+		server string
+		first  int
+		want   []int
+	}{
+		{"", 0, []int{1, 2, 3}},
+		{"", 10, []int{11, 12, 13}},
+		{"1.1.1.1:7777", 0, []int{1, 3}},
+		{"2.2.2.2:7777", 10, []int{12}},
+		{"3.3.3.3:7777", 0, nil},
+	}
+	for _, test := range tests {
+		filtered, ids := filterEntrysByServer(entrys, test.first, test.server)
+		if !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("%q from %d: got numbers %v, want %v", test.server, test.first, ids, test.want)
+			continue
+		}
+		for i, data := range filtered {
+			if data != entrys[ids[i]-test.first-1] {
+				t.Errorf("%q from %d: entry %d is not entry number %d", test.server, test.first, i, ids[i])
+			}
+		}
+	}
+}
+
+func TestDispatchUpdates(t *testing.T) {
+	const chats = 7
+	const messagesPerChat = 50
+
+	for _, workers := range []int{0, 1, 3, 16} {
+		updates := make(chan tgbotapi.Update, chats*messagesPerChat+1)
+		for n := 0; n < messagesPerChat; n++ {
+			for chat := 0; chat < chats; chat++ {
+				updates <- tgbotapi.Update{Message: &tgbotapi.Message{MessageID: n, Chat: &tgbotapi.Chat{ID: int64(chat)}}}
+			}
+		}
+		updates <- tgbotapi.Update{} // Updates without a message are skipped.
+		close(updates)
+
+		var mutex sync.Mutex
+		handled := make(map[int64][]int)
+		running := make(map[int64]bool)
+		dispatchUpdates(updates, workers, func(msg *tgbotapi.Message) {
+			mutex.Lock()
+			if running[msg.Chat.ID] {
+				t.Errorf("%d workers: chat %d is handled by two workers at once", workers, msg.Chat.ID)
+			}
+			running[msg.Chat.ID] = true
+			mutex.Unlock()
+
+			time.Sleep(time.Microsecond)
+
+			mutex.Lock()
+			running[msg.Chat.ID] = false
+			handled[msg.Chat.ID] = append(handled[msg.Chat.ID], msg.MessageID)
+			mutex.Unlock()
+		})
+
+		// dispatchUpdates returns after every message has been handled.
+		if len(handled) != chats {
+			t.Errorf("%d workers: handled %d chats, want %d", workers, len(handled), chats)
+		}
+		for chat, ids := range handled {
+			if len(ids) != messagesPerChat {
+				t.Errorf("%d workers: chat %d got %d messages, want %d", workers, chat, len(ids), messagesPerChat)
+			}
+			for i, id := range ids {
+				if id != i {
+					t.Errorf("%d workers: chat %d got messages %v, want them in order", workers, chat, ids)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestIsValidNickName(t *testing.T) {
+	tests := []struct {
+		nickname string
+		want     bool
+	}{
+		{"Mike_Tyson", true},
+		{"[ABC]_Bob", true},
+		{"a.b(c)$@=", true},
+		{"Вася_Пупкин", true},
+		{"abc", true},
+		{"ab", false},
+		{strings.Repeat("N", MaxNickNameLength), true},
+		{strings.Repeat("N", MaxNickNameLength+1), false},
+		{strings.Repeat("Я", MaxNickNameLength/2+1), false}, // 2 bytes each.
+		{"Mike Tyson", false},
+		{"Mike*", false},
+		{"Mike?", false},
+		{"a/b/c", false},
+	}
+	for _, test := range tests {
+		if got := isValidNickName(test.nickname); got != test.want {
+			t.Errorf("isValidNickName(%q) = %v, want %v", test.nickname, got, test.want)
+		}
+	}
+}
+
+func TestFormatNickNames(t *testing.T) {
+	nicknames := []string{"Mike_Tyson", "[ABC]*Bob", "a`b", "Alice"}
+	want := "`Mike\\_Tyson`\n`\\[ABC]\\*Bob`\n`a\\`b`\n`Alice`\n"
+	if got := formatNickNames(nicknames); got != want {
+		t.Errorf("formatNickNames(%q) = %q, want %q", nicknames, got, want)
+	}
+
+	nicknames = make([]string, maxNickNamesInMessage+3)
+	for i := range nicknames {
+		nicknames[i] = "Bob"
+	}
+	want = strings.Repeat("`Bob`\n", maxNickNamesInMessage) + "... и ещё 3.\n"
+	if got := formatNickNames(nicknames); got != want {
+		t.Errorf("formatNickNames() = %q, want %q", got, want)
+	}
+}
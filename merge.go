@@ -0,0 +1,104 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Both databases must be sorted by nickname. Nicknames are written to the new database in sorted order
+// and their data is sorted by time, so the result doesn't need SortFirstIndex and SortSecondIndex.
+func MergeDatabases(base *MordorLogsDB, fresh *MordorLogsDB, to *MordorLogsDB) error {
+	baseIt := base.FirstIndexIterator()
+	freshIt := fresh.FirstIndexIterator()
+
+	baseNickName, baseOffset, baseErr := baseIt.Next()
+	freshNickName, freshOffset, freshErr := freshIt.Next()
+	for {
+		if baseErr != nil && baseErr != ErrIterationDone {
+			return baseErr
+		}
+		if freshErr != nil && freshErr != ErrIterationDone {
+			return freshErr
+		}
+		baseDone := baseErr == ErrIterationDone
+		freshDone := freshErr == ErrIterationDone
+		if baseDone && freshDone {
+			break
+		}
+
+		var nickname string
+		var entrys []*DataEntry
+		var err error
+		if freshDone || (!baseDone && baseNickName < freshNickName) {
+			nickname = baseNickName
+			if entrys, err = base.readDataBySecondIndex(baseOffset); err != nil {
+				return err
+			}
+			baseNickName, baseOffset, baseErr = baseIt.Next()
+		} else if baseDone || freshNickName < baseNickName {
+			nickname = freshNickName
+			if entrys, err = fresh.readDataBySecondIndex(freshOffset); err != nil {
+				return err
+			}
+			freshNickName, freshOffset, freshErr = freshIt.Next()
+		} else { // The same nickname in both databases.
+			nickname = baseNickName
+			baseEntrys, err := base.readDataBySecondIndex(baseOffset)
+			if err != nil {
+				return err
+			}
+			freshEntrys, err := fresh.readDataBySecondIndex(freshOffset)
+			if err != nil {
+				return err
+			}
+			entrys = append(baseEntrys, freshEntrys...)
+			baseNickName, baseOffset, baseErr = baseIt.Next()
+			freshNickName, freshOffset, freshErr = freshIt.Next()
+		}
+
+		if err := to.WriteAll(nickname, sortUniqueDataEntrys(entrys)); err != nil {
+			return fmt.Errorf("to.WriteAll failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Sorts entrys by time and drops exact duplicates, so appending the same logs twice changes nothing.
+func sortUniqueDataEntrys(entrys []*DataEntry) []*DataEntry {
+	sort.SliceStable(entrys, func(i, j int) bool { return entrys[i].Time.Before(entrys[j].Time) })
+
+	unique := entrys[:0]
+	for _, data := range entrys {
+		duplicate := false
+		// Only entrys with the same time can be equal.
+		for i := len(unique) - 1; i >= 0 && unique[i].Time.Equal(data.Time); i-- {
+			if unique[i].Equal(data) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			unique = append(unique, data)
+		}
+	}
+	return unique
+}
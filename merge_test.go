@@ -0,0 +1,75 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSortUniqueDataEntrys(t *testing.T) {
+	entry := func(second int64, ip string, server string) *DataEntry {
+		return &DataEntry{Time: time.Unix(second, 0), IP: net.ParseIP(ip), Server: server}
+	}
+	tests := []struct {
+		name   string
+		entrys []*DataEntry
+		want   []*DataEntry
+	}{
+		{"empty", []*DataEntry{}, []*DataEntry{}},
+		{
+			"sorted by time",
+			[]*DataEntry{entry(3, "1.1.1.1", "a"), entry(1, "1.1.1.1", "a"), entry(2, "1.1.1.1", "a")},
+			[]*DataEntry{entry(1, "1.1.1.1", "a"), entry(2, "1.1.1.1", "a"), entry(3, "1.1.1.1", "a")},
+		},
+		{
+			"duplicates dropped",
+			[]*DataEntry{entry(2, "1.1.1.1", "a"), entry(1, "1.1.1.1", "a"), entry(2, "1.1.1.1", "a"), entry(1, "1.1.1.1", "a")},
+			[]*DataEntry{entry(1, "1.1.1.1", "a"), entry(2, "1.1.1.1", "a")},
+		},
+		{
+			"same time, different fields",
+			[]*DataEntry{entry(1, "1.1.1.1", "a"), entry(1, "2.2.2.2", "a"), entry(1, "1.1.1.1", "b"), entry(1, "2.2.2.2", "a")},
+			[]*DataEntry{entry(1, "1.1.1.1", "a"), entry(1, "2.2.2.2", "a"), entry(1, "1.1.1.1", "b")},
+		},
+		{
+			"duplicate separated by another entry of the same time",
+			[]*DataEntry{entry(1, "1.1.1.1", "a"), entry(1, "2.2.2.2", "a"), entry(1, "1.1.1.1", "a")},
+			[]*DataEntry{entry(1, "1.1.1.1", "a"), entry(1, "2.2.2.2", "a")},
+		},
+		{
+			"IPv4-mapped IPv6 equals IPv4",
+			[]*DataEntry{entry(1, "1.1.1.1", "a"), entry(1, "::ffff:1.1.1.1", "a")},
+			[]*DataEntry{entry(1, "1.1.1.1", "a")},
+		},
+	}
+	for _, test := range tests {
+		got := sortUniqueDataEntrys(test.entrys)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %d entrys, want %d", test.name, len(got), len(test.want))
+			continue
+		}
+		for i := range got {
+			if !got[i].Equal(test.want[i]) {
+				t.Errorf("%s: entry %d is %v, want %v", test.name, i, got[i], test.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,496 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Migration: Upgrades a database written by an older version into a new directory.
+// Every step converts a data entry of its version into the next version, the steps are chained
+// up to CurrentDatabaseVersion. The layout of the second index has not changed and the first index
+// only moved its nicknames into a heap in 1.5.0, so they are only read, and the new database is sorted
+// and indexed the same way as by build.
+// A new format version must add its step here.
+
+type migrationStep struct {
+	from uint32
+	to   uint32
+	// Size of FileHeader in the from version.
+	headerSize int64
+	// Reads the data entry of the from version at offset from the start of the file, without RecordLength.
+	readRecord func(file *os.File, offset int64) ([]byte, error)
+	// Converts the data entry without RecordLength, nickname is the nickname it belongs to.
+	upgrade func(nickname string, record []byte) ([]byte, error)
+}
+
+var migrationSteps = []migrationStep{
+	{databaseVersion(1, 0, 0), databaseVersion(1, 1, 0), 16 + 4 + 8, readUnprefixedRecord, upgradeToRecordLength},
+	{databaseVersion(1, 1, 0), databaseVersion(1, 2, 0), 16 + 4 + 8, readPrefixedRecord, upgradeToChecksum},
+	{databaseVersion(1, 2, 0), databaseVersion(1, 3, 0), 16 + 4 + 8 + 4, readPrefixedRecord, upgradeToNickName},
+	{databaseVersion(1, 3, 0), databaseVersion(1, 4, 0), 16 + 4 + 8 + 4, readPrefixedRecord, upgradeToTaggedIP},
+	{databaseVersion(1, 4, 0), databaseVersion(1, 5, 0), 16 + 4 + 8 + 4, readPrefixedRecord, upgradeToNickNameHeap},
+	{databaseVersion(1, 5, 0), databaseVersion(1, 6, 0), 16 + 4 + 8 + 4, readPrefixedRecord, upgradeToSealedFlag},
+	{databaseVersion(1, 6, 0), databaseVersion(1, 7, 0), 16 + 4 + 8 + 4, readPrefixedRecord, upgradeToLongNickName},
+}
+
+func databaseVersion(major, minor, patch uint32) uint32 {
+	return major<<24 | minor<<16 | patch<<8
+}
+
+// 1.0.0 entrys have no RecordLength, their size is known only after reading every string length.
+func readUnprefixedRecord(file *os.File, offset int64) ([]byte, error) {
+	b := make([]byte, 8+4+5*(1+255))
+	n, err := file.ReadAt(b, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	b = b[:n]
+	length := 8 + 4
+	for i := 0; i < 5; i++ {
+		if length >= len(b) {
+			return nil, ErrCorrupted
+		}
+		length += 1 + int(b[length])
+	}
+	if length > len(b) {
+		return nil, ErrCorrupted
+	}
+	return b[:length], nil
+}
+
+func readPrefixedRecord(file *os.File, offset int64) ([]byte, error) {
+	b := make([]byte, 2)
+	if _, err := file.ReadAt(b, offset); err != nil {
+		return nil, err
+	}
+	record := make([]byte, binary.LittleEndian.Uint16(b))
+	if _, err := file.ReadAt(record, offset+2); err == io.EOF {
+		return nil, ErrCorrupted
+	} else if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// 1.1.0 only adds RecordLength, which is not part of the record.
+func upgradeToRecordLength(nickname string, record []byte) ([]byte, error) {
+	return record, nil
+}
+
+func upgradeToChecksum(nickname string, record []byte) ([]byte, error) {
+	b := make([]byte, 4+len(record))
+	binary.LittleEndian.PutUint32(b[:4], crc32.Checksum(record, crc32cTable))
+	copy(b[4:], record)
+	return b, nil
+}
+
+func upgradeToNickName(nickname string, record []byte) ([]byte, error) {
+	if len(record) < 4 || binary.LittleEndian.Uint32(record[:4]) != crc32.Checksum(record[4:], crc32cTable) {
+		return nil, ErrChecksumMismatch
+	}
+	// NickNameLength was 1 byte until 1.7.0.
+	if len(nickname) > 255 {
+		return nil, ErrLongNickName
+	}
+	b := make([]byte, 4+1+len(nickname)+len(record)-4)
+	b[4] = uint8(len(nickname))
+	copy(b[5:], nickname)
+	copy(b[5+len(nickname):], record[4:])
+	binary.LittleEndian.PutUint32(b[:4], crc32.Checksum(b[4:], crc32cTable))
+	return b, nil
+}
+
+// Older entrys always have 4 bytes of IPv4 address after Time.
+func upgradeToTaggedIP(nickname string, record []byte) ([]byte, error) {
+	if len(record) < 4+1 || binary.LittleEndian.Uint32(record[:4]) != crc32.Checksum(record[4:], crc32cTable) {
+		return nil, ErrChecksumMismatch
+	}
+	ipOffset := 4 + 1 + int(record[4]) + 8
+	if len(record) < ipOffset+net.IPv4len {
+		return nil, ErrCorrupted
+	}
+	b := make([]byte, len(record)+1)
+	copy(b, record[:ipOffset])
+	b[ipOffset] = net.IPv4len
+	copy(b[ipOffset+1:], record[ipOffset:])
+	binary.LittleEndian.PutUint32(b[:4], crc32.Checksum(b[4:], crc32cTable))
+	return b, nil
+}
+
+// 1.5.0 only moves the nicknames of the first index into the heap file, data entrys are the same.
+func upgradeToNickNameHeap(nickname string, record []byte) ([]byte, error) {
+	return record, nil
+}
+
+// 1.6.0 only adds the sealed flag to the headers, which the migration writes again.
+func upgradeToSealedFlag(nickname string, record []byte) ([]byte, error) {
+	return record, nil
+}
+
+// Until 1.7.0 NickNameLength of data entrys was 1 byte.
+func upgradeToLongNickName(nickname string, record []byte) ([]byte, error) {
+	if len(record) < 4+1 || binary.LittleEndian.Uint32(record[:4]) != crc32.Checksum(record[4:], crc32cTable) {
+		return nil, ErrChecksumMismatch
+	}
+	b := make([]byte, len(record)+1)
+	binary.LittleEndian.PutUint16(b[4:6], uint16(record[4]))
+	copy(b[6:], record[5:])
+	binary.LittleEndian.PutUint32(b[:4], crc32.Checksum(b[4:], crc32cTable))
+	return b, nil
+}
+
+// A database of an older version, read without FirstIndexFile and others since their headers differ.
+type legacyDatabase struct {
+	dirPath     string
+	firstIndex  *os.File
+	secondIndex *os.File
+	data        *os.File
+	version     uint32
+	steps       []migrationStep
+}
+
+func openLegacyDatabase(dirPath string) (*legacyDatabase, error) {
+	m := &legacyDatabase{dirPath: dirPath}
+	files := []struct {
+		file   **os.File
+		name   string
+		marker [16]byte
+	}{
+		{&m.firstIndex, "first_index.bin", firstIndexHeaderMarker},
+		{&m.secondIndex, "second_index.bin", secondIndexHeaderMarker},
+		{&m.data, "data.bin", dataHeaderMarker},
+	}
+	for i, f := range files {
+		version, file, err := openLegacyFile(filepath.Join(dirPath, f.name), f.marker)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		*f.file = file
+		if i == 0 {
+			m.version = version
+		} else if version != m.version {
+			m.Close()
+			return nil, fmt.Errorf("%s has version %s, but first_index.bin has %s", f.name, formatVersion(version), formatVersion(m.version))
+		}
+	}
+
+	for version := m.version; version != CurrentDatabaseVersion; {
+		found := false
+		for _, step := range migrationSteps {
+			if step.from == version {
+				m.steps = append(m.steps, step)
+				version, found = step.to, true
+				break
+			}
+		}
+		if !found {
+			m.Close()
+			return nil, fmt.Errorf("%w: no migration from version %s", ErrIncompatibleVersions, formatVersion(version))
+		}
+	}
+	return m, nil
+}
+
+// Returns the version from the header without the flags. Marker and Version are at the same place in every version.
+func openLegacyFile(filePath string, marker [16]byte) (uint32, *os.File, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, nil, err
+	}
+	b := make([]byte, 16+4)
+	if _, err := file.ReadAt(b, 0); err != nil {
+		file.Close()
+		return 0, nil, err
+	}
+	if !bytes.Equal(b[:16], marker[:]) {
+		file.Close()
+		return 0, nil, fmt.Errorf("%s: %w", filePath, ErrCorrupted)
+	}
+	return binary.LittleEndian.Uint32(b[16:]) &^ fileFlagsMask, file, nil
+}
+
+func (m *legacyDatabase) Close() {
+	for _, file := range []*os.File{m.firstIndex, m.secondIndex, m.data} {
+		if file != nil {
+			file.Close()
+		}
+	}
+}
+
+func (m *legacyDatabase) headerSize() int64 {
+	if len(m.steps) == 0 {
+		return fileHeaderSize
+	}
+	return m.steps[0].headerSize
+}
+
+// Returns the entry at offset upgraded to the current version.
+func (m *legacyDatabase) readDataEntry(nickname string, offset uint64) (*DataEntry, error) {
+	readRecord := readPrefixedRecord
+	if len(m.steps) != 0 {
+		readRecord = m.steps[0].readRecord
+	}
+	record, err := readRecord(m.data, m.headerSize()+int64(offset))
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range m.steps {
+		if record, err = step.upgrade(nickname, record); err != nil {
+			return nil, err
+		}
+	}
+	recNickName, entry, err := decodeDataEntry(record)
+	if err != nil {
+		return nil, err
+	}
+	if recNickName != nickname {
+		return nil, ErrCorrupted
+	}
+	return entry, nil
+}
+
+// Before 1.5.0 the first index entrys kept the nickname inline, zero-padded to 24 bytes.
+const inlineFirstIndexEntrySize = 24 + 8
+
+// From 1.5.0 to 1.6.0 NickNameLength of the first index entrys was 1 byte.
+const shortFirstIndexEntrySize = 8 + 1 + 8
+
+func (m *legacyDatabase) inlineNickNames() bool {
+	return m.version < databaseVersion(1, 5, 0)
+}
+
+func (m *legacyDatabase) firstIndexEntrySize() int64 {
+	if m.inlineNickNames() {
+		return inlineFirstIndexEntrySize
+	} else if m.version < databaseVersion(1, 7, 0) {
+		return shortFirstIndexEntrySize
+	}
+	return firstIndexEntrySize
+}
+
+// Returns the nickname offset and length and the offset to the second index of a first index entry with the nickname in the heap.
+func (m *legacyDatabase) parseFirstIndexEntry(entry []byte) (nicknameOffset uint64, nicknameLength int, offset uint64) {
+	if len(entry) == shortFirstIndexEntrySize {
+		return binary.LittleEndian.Uint64(entry[0:8]), int(entry[8]), binary.LittleEndian.Uint64(entry[9:17])
+	}
+	return parseFirstIndexEntry(entry)
+}
+
+// Returns a function reading the next nickname and its offset to the second index, it returns ErrIterationDone at the end.
+func (m *legacyDatabase) firstIndexIterator() (next func() (string, uint64, error), closeIndex func(), err error) {
+	var heap *os.File
+	if !m.inlineNickNames() {
+		var version uint32
+		version, heap, err = openLegacyFile(nicknameHeapPath(filepath.Join(m.dirPath, "first_index.bin")), nicknameHeapHeaderMarker)
+		if err != nil {
+			return nil, nil, err
+		}
+		if version != m.version {
+			heap.Close()
+			return nil, nil, fmt.Errorf("%w: nickname heap has version %s", ErrIncompatibleVersions, formatVersion(version))
+		}
+	}
+
+	r := bufio.NewReaderSize(io.NewSectionReader(m.firstIndex, m.headerSize(), 1<<62), dataFileIteratorBufferSize)
+	entry := make([]byte, m.firstIndexEntrySize())
+	next = func() (string, uint64, error) {
+		if _, err := io.ReadFull(r, entry); err == io.EOF {
+			return "", 0, ErrIterationDone
+		} else if err == io.ErrUnexpectedEOF {
+			return "", 0, ErrCorrupted
+		} else if err != nil {
+			return "", 0, err
+		}
+		if heap == nil {
+			nick := entry[:24]
+			if zeroIndex := bytes.IndexByte(nick, 0x00); zeroIndex != -1 {
+				nick = nick[:zeroIndex]
+			}
+			return string(nick), binary.LittleEndian.Uint64(entry[24:32]), nil
+		}
+		nicknameOffset, nicknameLength, offset := m.parseFirstIndexEntry(entry)
+		nick := make([]byte, nicknameLength)
+		if _, err := heap.ReadAt(nick, m.headerSize()+int64(nicknameOffset)); err == io.EOF {
+			return "", 0, ErrCorrupted
+		} else if err != nil {
+			return "", 0, err
+		}
+		return string(nick), offset, nil
+	}
+	closeIndex = func() {
+		if heap != nil {
+			heap.Close()
+		}
+	}
+	return next, closeIndex, nil
+}
+
+// Counts the nicknames by the size of the first index and the entrys by walking the second index,
+// so the migrated database can be checked against the old files rather than against copyTo.
+func (m *legacyDatabase) count() (nicknames int, entrys int, err error) {
+	entrySize := m.firstIndexEntrySize()
+	stat, err := m.firstIndex.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := stat.Size() - m.headerSize()
+	if size < 0 || size%entrySize != 0 {
+		return 0, 0, fmt.Errorf("first_index.bin: %w", ErrCorrupted)
+	}
+	nicknames = int(size / entrySize)
+
+	r := bufio.NewReaderSize(io.NewSectionReader(m.secondIndex, m.headerSize(), 1<<62), dataFileIteratorBufferSize)
+	b := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, b); err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			return 0, 0, fmt.Errorf("second_index.bin: %w", ErrCorrupted)
+		} else if err != nil {
+			return 0, 0, err
+		}
+		offsetCount := int(binary.LittleEndian.Uint32(b))
+		if _, err := r.Discard(offsetCount * 8); err == io.EOF {
+			return 0, 0, fmt.Errorf("second_index.bin: %w", ErrCorrupted)
+		} else if err != nil {
+			return 0, 0, err
+		}
+		entrys += offsetCount
+	}
+	return nicknames, entrys, nil
+}
+
+// Writes every nickname with its entrys into to, in the order of the old first index.
+func (m *legacyDatabase) copyTo(to *MordorLogsDB) error {
+	next, closeIndex, err := m.firstIndexIterator()
+	if err != nil {
+		return err
+	}
+	defer closeIndex()
+	nicknameCount, entryCount := 0, 0
+	for {
+		nickname, offsetToSecondIndex, err := next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		offsetsToData, _, err := readSecondIndexEntry(m.secondIndex, nil, m.headerSize()+int64(offsetToSecondIndex))
+		if err != nil {
+			return err
+		}
+		entrys := make([]*DataEntry, len(offsetsToData))
+		for i, offset := range offsetsToData {
+			if entrys[i], err = m.readDataEntry(nickname, offset); err != nil {
+				return fmt.Errorf("entry %d of %s: %w", i+1, nickname, err)
+			}
+		}
+		if err := to.WriteAll(nickname, entrys); err != nil {
+			return fmt.Errorf("to.WriteAll failed: %w", err)
+		}
+
+		nicknameCount++
+		entryCount += len(entrys)
+	}
+	log.Println("Nicknames:", nicknameCount, "entrys:", entryCount)
+	return nil
+}
+
+// Rewrites the database at dbPath into outPath in the current version. The old database is not changed.
+// The new one is checked with VerifyDatabase and must have as many nicknames and entrys as counted in the old files.
+func MigrateDatabase(dbPath string, outPath string, options BuildOptions) error {
+	dbPath, outPath = filepath.Clean(dbPath), filepath.Clean(outPath)
+	if _, err := os.Stat(outPath); err == nil {
+		return fmt.Errorf("%s already exists", outPath)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	old, err := openLegacyDatabase(readableDirPath(dbPath))
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	oldNickNames, oldEntrys, err := old.count()
+	if err != nil {
+		return err
+	}
+
+	tmpPath, err := ioutil.TempDir(filepath.Dir(outPath), "."+filepath.Base(outPath)+".migrate-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpPath)
+
+	log.Printf("Migrating %s from version %s to %s.", dbPath, formatVersion(old.version), formatVersion(CurrentDatabaseVersion))
+	migrateStart := time.Now()
+
+	sortedPath, err := buildSortedDatabase("MigrateEntrys", old.copyTo, tmpPath, 8, options)
+	if err != nil {
+		return err
+	}
+	if err := buildKeyIndexes(sortedPath, tmpPath, 5, 8, options); err != nil {
+		return err
+	}
+	if err := buildClusters(sortedPath, 6, 8, options); err != nil {
+		return err
+	}
+	if err := runBuildStage(7, 8, "SealDatabase", func() error { return SealDatabase(sortedPath) }); err != nil {
+		return err
+	}
+
+	err = runBuildStage(8, 8, "VerifyDatabase", func() error {
+		result, err := VerifyDatabase(sortedPath, func(c Corruption) { log.Println(c.String()) })
+		if err != nil {
+			return err
+		}
+		if result.Corruptions != 0 {
+			return fmt.Errorf("found %d corruptions", result.Corruptions)
+		}
+		if result.NickNames != oldNickNames || result.DataEntrys != oldEntrys {
+			return fmt.Errorf("%d nicknames and %d entrys were migrated, but the old database has %d and %d",
+				result.NickNames, result.DataEntrys, oldNickNames, oldEntrys)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := replaceDir(sortedPath, outPath); err != nil {
+		return err
+	}
+
+	log.Printf("Database %s migrated to %s in %s.", dbPath, outPath, time.Since(migrateStart).Round(time.Millisecond))
+	return nil
+}
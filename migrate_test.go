@@ -0,0 +1,270 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrationStepsReachCurrentVersion(t *testing.T) {
+	version := databaseVersion(1, 0, 0)
+	for range migrationSteps {
+		if version == CurrentDatabaseVersion {
+			break
+		}
+		found := false
+		for _, step := range migrationSteps {
+			if step.from == version {
+				if step.to <= step.from {
+					t.Fatalf("step from %s goes back to %s", formatVersion(step.from), formatVersion(step.to))
+				}
+				version, found = step.to, true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("no migration from version %s", formatVersion(version))
+		}
+	}
+	if version != CurrentDatabaseVersion {
+		t.Fatalf("migration steps end at %s, current version is %s", formatVersion(version), formatVersion(CurrentDatabaseVersion))
+	}
+}
+
+// Returns the data entry as it was written by the version, without RecordLength.
+func legacyTestRecord(version uint32, nickname string, entry *DataEntry) []byte {
+	if version >= databaseVersion(1, 7, 0) {
+		record, _ := encodeDataEntry(nickname, entry)
+		return record[2:]
+	}
+	var body bytes.Buffer
+	if version >= databaseVersion(1, 3, 0) {
+		body.WriteByte(uint8(len(nickname)))
+		body.WriteString(nickname)
+	}
+	binary.Write(&body, binary.LittleEndian, uint64(entry.Time.Unix()))
+	if version >= databaseVersion(1, 4, 0) {
+		ip := dataEntryIP(entry.IP)
+		body.WriteByte(uint8(len(ip)))
+		body.Write(ip)
+	} else {
+		body.Write(entry.IP.To4())
+	}
+	for _, str := range []string{entry.Android, entry.Brand, entry.Model, entry.Fingerprint, entry.Server} {
+		body.WriteByte(uint8(len(str)))
+		body.WriteString(str)
+	}
+	if version < databaseVersion(1, 2, 0) {
+		return body.Bytes()
+	}
+	record := make([]byte, 4, 4+body.Len())
+	binary.LittleEndian.PutUint32(record, crc32.Checksum(body.Bytes(), crc32cTable))
+	return append(record, body.Bytes()...)
+}
+
+func TestMigrationStepUpgrades(t *testing.T) {
+	entry := &DataEntry{time.Unix(1596315888, 0), net.ParseIP("10.0.2.4"), "10", "Xiaomi", "Mi 10", "fp/dev4", "1.1.1.1:7777"}
+	for _, nickname := range []string{"Mike_Tyson", strings.Repeat("n", 24), strings.Repeat("n", 255)} {
+		for _, step := range migrationSteps {
+			got, err := step.upgrade(nickname, legacyTestRecord(step.from, nickname, entry))
+			if err != nil {
+				t.Errorf("%s to %s: %v", formatVersion(step.from), formatVersion(step.to), err)
+				continue
+			}
+			if want := legacyTestRecord(step.to, nickname, entry); !bytes.Equal(got, want) {
+				t.Errorf("%s to %s: got %x, want %x", formatVersion(step.from), formatVersion(step.to), got, want)
+			}
+		}
+	}
+
+	damaged := legacyTestRecord(databaseVersion(1, 2, 0), "Bob", entry)
+	damaged[len(damaged)-1] ^= 0x01
+	if _, err := upgradeToNickName("Bob", damaged); err != ErrChecksumMismatch {
+		t.Errorf("upgradeToNickName of a damaged entry: %v", err)
+	}
+	if _, err := upgradeToNickName(strings.Repeat("n", 256), legacyTestRecord(databaseVersion(1, 2, 0), "Bob", entry)); err != ErrLongNickName {
+		t.Errorf("upgradeToNickName of a long nickname: %v", err)
+	}
+	damaged = legacyTestRecord(databaseVersion(1, 3, 0), "Bob", entry)
+	damaged[len(damaged)-1] ^= 0x01
+	if _, err := upgradeToTaggedIP("Bob", damaged); err != ErrChecksumMismatch {
+		t.Errorf("upgradeToTaggedIP of a damaged entry: %v", err)
+	}
+	damaged = legacyTestRecord(databaseVersion(1, 6, 0), "Bob", entry)
+	damaged[len(damaged)-1] ^= 0x01
+	if _, err := upgradeToLongNickName("Bob", damaged); err != ErrChecksumMismatch {
+		t.Errorf("upgradeToLongNickName of a damaged entry: %v", err)
+	}
+}
+
+// Writes first_index.bin, second_index.bin and data.bin (and the nickname heap since 1.5.0) the way the version did.
+func writeLegacyTestDatabase(t *testing.T, dirPath string, version uint32, nicknames []string, entrys [][]*DataEntry) {
+	header := func(marker [16]byte) []byte {
+		b := make([]byte, 16+4+8, 16+4+8+4)
+		copy(b, marker[:])
+		if version >= databaseVersion(1, 6, 0) {
+			binary.LittleEndian.PutUint32(b[16:], version|fileSealedFlag)
+		} else {
+			binary.LittleEndian.PutUint32(b[16:], version)
+		}
+		if version >= databaseVersion(1, 2, 0) {
+			b = append(b, 0, 0, 0, 0)
+		}
+		return b
+	}
+	firstIndex := header(firstIndexHeaderMarker)
+	heap := header(nicknameHeapHeaderMarker)
+	secondIndex := header(secondIndexHeaderMarker)
+	data := header(dataHeaderMarker)
+	headerSize := len(data)
+
+	for i, nickname := range nicknames {
+		secondIndexOffset := len(secondIndex) - headerSize
+		secondIndex = append(secondIndex, make([]byte, 4+8*len(entrys[i]))...)
+		binary.LittleEndian.PutUint32(secondIndex[headerSize+secondIndexOffset:], uint32(len(entrys[i])))
+		for j, entry := range entrys[i] {
+			binary.LittleEndian.PutUint64(secondIndex[headerSize+secondIndexOffset+4+8*j:], uint64(len(data)-headerSize))
+			record := legacyTestRecord(version, nickname, entry)
+			if version >= databaseVersion(1, 1, 0) {
+				data = append(data, uint8(len(record)), uint8(len(record)>>8))
+			}
+			data = append(data, record...)
+		}
+
+		entry := make([]byte, inlineFirstIndexEntrySize)
+		if version < databaseVersion(1, 5, 0) {
+			copy(entry[:24], nickname)
+			binary.LittleEndian.PutUint64(entry[24:], uint64(secondIndexOffset))
+		} else {
+			entry = entry[:shortFirstIndexEntrySize]
+			binary.LittleEndian.PutUint64(entry[0:8], uint64(len(heap)-headerSize))
+			entry[8] = uint8(len(nickname))
+			binary.LittleEndian.PutUint64(entry[9:17], uint64(secondIndexOffset))
+			heap = append(heap, nickname...)
+		}
+		firstIndex = append(firstIndex, entry...)
+	}
+
+	files := map[string][]byte{"first_index.bin": firstIndex, "second_index.bin": secondIndex, "data.bin": data}
+	if version >= databaseVersion(1, 5, 0) {
+		files[nicknameHeapPath("first_index.bin")] = heap
+	}
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, b := range files {
+		if err := ioutil.WriteFile(filepath.Join(dirPath, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrateDatabase(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "mordorlogs-test-migrate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	entry := func(t string, ip string) *DataEntry {
+		tm, _ := time.ParseInLocation(timeFormatLayout, t, time.UTC)
+		return &DataEntry{tm, net.ParseIP(ip), "10", "Xiaomi", "Mi 10", "fp/" + ip, "1.1.1.1:7777"}
+	}
+	// Nicknames of the old first index are not sorted, the migrated database is.
+	nicknames := []string{"Mike_Tyson", "Alice", "[ABC]_Bob"}
+	entrys := [][]*DataEntry{
+		{entry("02.08.2020 06:26:11", "10.0.2.4"), entry("05.08.2020 12:00:00", "10.0.0.2")},
+		{entry("05.08.2020 08:30:00", "192.168.1.1")},
+		{entry("02.08.2020 10:00:00", "192.168.1.1"), entry("01.08.2020 10:00:00", "172.16.0.1")},
+	}
+	want := []string{
+		"Alice 05.08.2020 08:30:00 192.168.1.1",
+		"Mike_Tyson 02.08.2020 06:26:11 10.0.2.4",
+		"Mike_Tyson 05.08.2020 12:00:00 10.0.0.2",
+		"[ABC]_Bob 01.08.2020 10:00:00 172.16.0.1",
+		"[ABC]_Bob 02.08.2020 10:00:00 192.168.1.1",
+	}
+
+	tests := []struct {
+		version uint32
+		// Appended to second_index.bin, so the old database has an entry that was not migrated.
+		extraSecondIndexEntry bool
+	}{
+		{databaseVersion(1, 0, 0), false},
+		{databaseVersion(1, 1, 0), false},
+		{databaseVersion(1, 2, 0), false},
+		{databaseVersion(1, 3, 0), false},
+		{databaseVersion(1, 4, 0), false},
+		{databaseVersion(1, 5, 0), false},
+		{databaseVersion(1, 6, 0), false},
+		{databaseVersion(1, 6, 0), true},
+	}
+	for i, test := range tests {
+		name := formatVersion(test.version)
+		oldPath := filepath.Join(dirPath, "old"+name)
+		outPath := filepath.Join(dirPath, "new"+name)
+		if test.extraSecondIndexEntry {
+			oldPath += "-extra"
+			outPath += "-extra"
+		}
+		writeLegacyTestDatabase(t, oldPath, test.version, nicknames, entrys)
+		if test.extraSecondIndexEntry {
+			f, err := os.OpenFile(filepath.Join(oldPath, "second_index.bin"), os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+			f.Close()
+		}
+
+		err := MigrateDatabase(oldPath, outPath, BuildOptions{MemoryLimit: 1 << 10})
+		if test.extraSecondIndexEntry {
+			if err == nil {
+				t.Errorf("test %d, %s: migrated with an entry missing", i, name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d, %s: %v", i, name, err)
+			continue
+		}
+		mldb, err := OpenReadOnly(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := mldb.scanNickNames("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readTestEntrys(t, it); !reflect.DeepEqual(got, want) {
+			t.Errorf("test %d, %s: got %q, want %q", i, name, got, want)
+		}
+		mldb.Close()
+	}
+}
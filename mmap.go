@@ -0,0 +1,40 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// Returns n bytes of the file at offset. If the file is mapped the bytes are sliced out of the mapping
+// without a copy, so they must not be modified or kept after the file is closed.
+func readBytesAt(file *os.File, mapped []byte, offset int64, n int) ([]byte, error) {
+	if mapped != nil {
+		if offset < 0 || n < 0 || offset+int64(n) > int64(len(mapped)) {
+			return nil, io.EOF
+		}
+		return mapped[offset : offset+int64(n)], nil
+	}
+	b := make([]byte, n)
+	if _, err := file.ReadAt(b, offset); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
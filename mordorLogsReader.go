@@ -0,0 +1,123 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// Read-only handle to a database, safe for concurrent use by many goroutines.
+// Lookups of MordorLogsDB only read the files with ReadAt (or from the mapping) and keep their state
+// in the returned iterators, so they can run in parallel as long as nothing is written.
+// The reader has no write methods, which guarantees that. Iterators themselves must not be shared.
+type MordorLogsReader struct {
+	db *MordorLogsDB
+}
+
+// Opens an existing database, mapping it into memory if mmap is true and the platform supports it.
+func OpenMordorLogsReader(dirPath string, mmap bool) (*MordorLogsReader, error) {
+	if mmap {
+		mldb, err := NewMappedMordorLogsDB(dirPath)
+		if err != ErrMmapUnsupported {
+			if err != nil {
+				return nil, err
+			}
+			return &MordorLogsReader{mldb}, nil
+		}
+	}
+	mldb, err := OpenReadOnly(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	return &MordorLogsReader{mldb}, nil
+}
+
+// Must not be called while lookups are running.
+func (m *MordorLogsReader) Close() error {
+	return m.db.Close()
+}
+
+func (m *MordorLogsReader) GetEntryCount() int {
+	return m.db.GetEntryCount()
+}
+
+func (m *MordorLogsReader) GetNickNameByID(nicknameID uint32) (string, error) {
+	return m.db.GetNickNameByID(nicknameID)
+}
+
+func (m *MordorLogsReader) FirstIndexIterator() *FirstIndexIterator {
+	return m.db.FirstIndexIterator()
+}
+
+func (m *MordorLogsReader) FindDataByNickName(nickname string) ([]*DataEntry, error) {
+	return m.db.FindDataByNickName(nickname)
+}
+
+func (m *MordorLogsReader) FindDataByNickNameBetween(nickname string, from time.Time, to time.Time) ([]*DataEntry, int, error) {
+	return m.db.FindDataByNickNameBetween(nickname, from, to)
+}
+
+func (m *MordorLogsReader) FindByNickNamePrefix(prefix string, limit int) ([]string, error) {
+	return m.db.FindByNickNamePrefix(prefix, limit)
+}
+
+func (m *MordorLogsReader) FindByNickNamePrefixFold(prefix string, limit int) ([]string, error) {
+	return m.db.FindByNickNamePrefixFold(prefix, limit)
+}
+
+func (m *MordorLogsReader) SearchNickNames(pattern *NickNamePattern, limit int, timeout time.Duration) ([]string, bool, error) {
+	return m.db.SearchNickNames(pattern, limit, timeout)
+}
+
+func (m *MordorLogsReader) FindNickNamesByIP(ip net.IP) ([]string, error) {
+	return m.db.FindNickNamesByIP(ip)
+}
+
+func (m *MordorLogsReader) FindNickNamesByIPOnServer(ip net.IP, server string) ([]string, error) {
+	return m.db.FindNickNamesByIPOnServer(ip, server)
+}
+
+func (m *MordorLogsReader) FindNickNamesByFingerprint(fingerprint string) ([]string, error) {
+	return m.db.FindNickNamesByFingerprint(fingerprint)
+}
+
+func (m *MordorLogsReader) FindNickNamesByFingerprintOnServer(fingerprint string, server string) ([]string, error) {
+	return m.db.FindNickNamesByFingerprintOnServer(fingerprint, server)
+}
+
+func (m *MordorLogsReader) FindByIPRange(subnet net.IPNet) (*KeyRangeIterator, error) {
+	return m.db.FindByIPRange(subnet)
+}
+
+func (m *MordorLogsReader) FindByTimeRange(from time.Time, to time.Time) (*KeyRangeIterator, error) {
+	return m.db.FindByTimeRange(from, to)
+}
+
+func (m *MordorLogsReader) FindByServer(server string, from time.Time, to time.Time) (*KeyRangeIterator, error) {
+	return m.db.FindByServer(server, from, to)
+}
+
+func (m *MordorLogsReader) FindCluster(nickname string) ([]string, []ClusterLink, error) {
+	return m.db.FindCluster(nickname)
+}
+
+func (m *MordorLogsReader) RunQuery(q *Query) (*QueryIterator, error) {
+	return m.db.RunQuery(q)
+}
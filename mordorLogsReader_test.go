@@ -0,0 +1,113 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Runs every lookup of the reader once and describes the results.
+func readerTestLookups(mldb *MordorLogsReader) ([]string, error) {
+	results := make([]string, 0)
+	for _, nickname := range []string{"Mike_Tyson", "Alice", "[ABC]_Bob", "john_doe"} {
+		entrys, err := mldb.FindDataByNickName(nickname)
+		if err != nil {
+			return nil, err
+		}
+		for _, data := range entrys {
+			results = append(results, fmt.Sprint(nickname, data.Time.Unix(), data.IP, data.Server))
+		}
+		members, links, err := mldb.FindCluster(nickname)
+		if err != nil && err != ErrNoCluster {
+			return nil, err
+		}
+		results = append(results, fmt.Sprint(members, links))
+	}
+	for _, ip := range []string{"192.168.1.1", "10.0.2.4"} {
+		nicknames, err := mldb.FindNickNamesByIP(net.ParseIP(ip))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, fmt.Sprint(nicknames))
+	}
+	nicknames, err := mldb.FindByNickNamePrefixFold("john", 10)
+	if err != nil {
+		return nil, err
+	}
+	results = append(results, fmt.Sprint(nicknames))
+
+	it, err := mldb.FindByTimeRange(time.Unix(0, 0), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for {
+		nickname, data, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		results = append(results, fmt.Sprint(nickname, data.Time.Unix()))
+	}
+	return results, nil
+}
+
+func TestMordorLogsReaderConcurrent(t *testing.T) {
+	dbPath, remove := buildTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	for _, mmap := range []bool{false, true} {
+		mldb, err := OpenMordorLogsReader(dbPath, mmap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := readerTestLookups(mldb)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		const readers = 8
+		results := make([][]string, readers)
+		errs := make([]error, readers)
+		var wg sync.WaitGroup
+		for i := 0; i < readers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for n := 0; n < 20 && errs[i] == nil; n++ {
+					results[i], errs[i] = readerTestLookups(mldb)
+				}
+			}(i)
+		}
+		wg.Wait()
+		for i := range results {
+			if errs[i] != nil {
+				t.Errorf("mmap %v, reader %d: %v", mmap, i, errs[i])
+			} else if !reflect.DeepEqual(results[i], want) {
+				t.Errorf("mmap %v, reader %d: got %q, want %q", mmap, i, results[i], want)
+			}
+		}
+		mldb.Close()
+	}
+}
@@ -19,14 +19,28 @@
 package main
 
 import (
+	"bytes"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 type MordorLogsDB struct {
 	firstIndex  FirstIndexFile
 	secondIndex SecondIndexFile
 	data        DataFile
+
+	// Optional, nil if the database was built without them.
+	ipIndex          *KeyIndexFile
+	fingerprintIndex *KeyIndexFile
+	timeIndex        *KeyIndexFile
+	serverIndex      *KeyIndexFile
+	foldedIndex      *FoldedIndexFile
+	clusters         *ClusterFile
+
+	readOnly bool
 }
 
 func (m *MordorLogsDB) Open(dirPath string) (isnew bool, err error) {
@@ -49,6 +63,11 @@ func (m *MordorLogsDB) Open(dirPath string) (isnew bool, err error) {
 		return false, err
 	}
 
+	if err = m.openOptionalIndexes(dirPath, false); err != nil {
+		m.Close()
+		return false, err
+	}
+
 	n := 0
 	if firstIndexIsNew {
 		n++
@@ -68,7 +87,134 @@ func (m *MordorLogsDB) Open(dirPath string) (isnew bool, err error) {
 	return allFilesIsNew, nil
 }
 
+// Opens an existing database for reading only. Unlike Open it never creates or modifies files
+// and fails if first_index.bin, second_index.bin or data.bin is missing. Writes return ErrReadOnly.
+// A dirPath left missing by an interrupted replaceDir is read from dirPath.old.
+func (m *MordorLogsDB) OpenReadOnly(dirPath string) error {
+	dirPath = readableDirPath(dirPath)
+	if err := m.firstIndex.OpenReadOnly(filepath.Join(dirPath, "first_index.bin")); err != nil {
+		return err
+	}
+	if err := m.secondIndex.OpenReadOnly(filepath.Join(dirPath, "second_index.bin")); err != nil {
+		m.firstIndex.Close()
+		return err
+	}
+	if err := m.data.OpenReadOnly(filepath.Join(dirPath, "data.bin")); err != nil {
+		m.firstIndex.Close()
+		m.secondIndex.Close()
+		return err
+	}
+	m.readOnly = true
+
+	if err := m.openOptionalIndexes(dirPath, true); err != nil {
+		m.Close()
+		return err
+	}
+	return nil
+}
+
+func (m *MordorLogsDB) openOptionalIndexes(dirPath string, readOnly bool) (err error) {
+	if m.ipIndex, err = openKeyIndex(NewIPIndexFile(), filepath.Join(dirPath, "ip_index.bin"), readOnly); err != nil {
+		return err
+	}
+	if m.fingerprintIndex, err = openKeyIndex(NewFingerprintIndexFile(), filepath.Join(dirPath, "fingerprint_index.bin"), readOnly); err != nil {
+		return err
+	}
+	if m.timeIndex, err = openKeyIndex(NewTimeIndexFile(), filepath.Join(dirPath, "time_index.bin"), readOnly); err != nil {
+		return err
+	}
+	if m.serverIndex, err = openKeyIndex(NewServerIndexFile(), filepath.Join(dirPath, "server_index.bin"), readOnly); err != nil {
+		return err
+	}
+
+	foldedIndexPath := filepath.Join(dirPath, "folded_index.bin")
+	if _, err := os.Stat(foldedIndexPath); err == nil {
+		foldedIndex := new(FoldedIndexFile)
+		if readOnly {
+			err = foldedIndex.OpenReadOnly(foldedIndexPath)
+		} else {
+			_, err = foldedIndex.Open(foldedIndexPath)
+		}
+		if err != nil {
+			if foldedIndex.file != nil {
+				foldedIndex.file.Close()
+			}
+			return err
+		}
+		m.foldedIndex = foldedIndex
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	clustersPath := filepath.Join(dirPath, "cluster.bin")
+	if _, err := os.Stat(clustersPath); err == nil {
+		clusters := new(ClusterFile)
+		if err := clusters.Open(clustersPath); err != nil {
+			if clusters.file != nil {
+				clusters.file.Close()
+			}
+			return err
+		}
+		m.clusters = clusters
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Key indexes are built after sorting, so a database without them is still valid.
+func openKeyIndex(index *KeyIndexFile, filePath string, readOnly bool) (*KeyIndexFile, error) {
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var err error
+	if readOnly {
+		err = index.OpenReadOnly(filePath)
+	} else {
+		_, err = index.Open(filePath)
+	}
+	if err != nil {
+		if index.file != nil {
+			index.file.Close()
+		}
+		return nil, err
+	}
+	return index, nil
+}
+
 func (m *MordorLogsDB) Close() error {
+	if m.clusters != nil {
+		if err := m.clusters.Close(); err != nil {
+			return err
+		}
+	}
+	if m.foldedIndex != nil {
+		if err := m.foldedIndex.Close(); err != nil {
+			return err
+		}
+	}
+	if m.serverIndex != nil {
+		if err := m.serverIndex.Close(); err != nil {
+			return err
+		}
+	}
+	if m.timeIndex != nil {
+		if err := m.timeIndex.Close(); err != nil {
+			return err
+		}
+	}
+	if m.fingerprintIndex != nil {
+		if err := m.fingerprintIndex.Close(); err != nil {
+			return err
+		}
+	}
+	if m.ipIndex != nil {
+		if err := m.ipIndex.Close(); err != nil {
+			return err
+		}
+	}
 	if err := m.data.Close(); err != nil {
 		return err
 	}
@@ -82,6 +228,9 @@ func (m *MordorLogsDB) Close() error {
 }
 
 func (m *MordorLogsDB) SyncFiles() error {
+	if err := m.Flush(); err != nil {
+		return err
+	}
 	if err := m.firstIndex.Sync(); err != nil {
 		return err
 	}
@@ -94,6 +243,25 @@ func (m *MordorLogsDB) SyncFiles() error {
 	return nil
 }
 
+// Buffers writes to first_index.bin, second_index.bin and data.bin in memory, up to bufferSize bytes per file.
+// Written entrys can't be read before Flush or SyncFiles.
+func (m *MordorLogsDB) EnableBulkWrite(bufferSize int) {
+	m.firstIndex.EnableBulkWrite(bufferSize)
+	m.secondIndex.EnableBulkWrite(bufferSize)
+	m.data.EnableBulkWrite(bufferSize)
+}
+
+// Data is flushed before the indexes, so they never point past the end of the written data.
+func (m *MordorLogsDB) Flush() error {
+	if err := m.data.Flush(); err != nil {
+		return err
+	}
+	if err := m.secondIndex.Flush(); err != nil {
+		return err
+	}
+	return m.firstIndex.Flush()
+}
+
 func (m *MordorLogsDB) GetEntryCount() int {
 	return m.firstIndex.GetEntryCount()
 }
@@ -104,7 +272,10 @@ func (m *MordorLogsDB) Write(nickname string, data DataEntry) error {
 }
 
 func (m *MordorLogsDB) WriteAll(nickname string, entrys []*DataEntry) error {
-	if len(nickname) > 24 {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	if len(nickname) > MaxNickNameLength {
 		return ErrLongNickName
 	}
 	entrysLen := len(entrys)
@@ -123,7 +294,7 @@ func (m *MordorLogsDB) WriteAll(nickname string, entrys []*DataEntry) error {
 
 	offsetsToData := make([]uint64, entrysLen)
 	for i, data := range entrys {
-		dataOffset, err := m.data.WriteEntry(*data)
+		dataOffset, err := m.data.WriteEntry(nickname, *data)
 		if err != nil {
 			return err
 		}
@@ -178,6 +349,141 @@ func (m *MordorLogsDB) FindDataByNickName(nickname string) ([]*DataEntry, error)
 	return m.readDataBySecondIndex(offsetToSecondIndex)
 }
 
+// Returns entrys with time from..to inclusive. Second index entrys must be sorted by time (SortSecondIndex),
+// so only the matching data entrys are read. first is the number of the first returned entry
+// among all entrys of the nickname.
+func (m *MordorLogsDB) FindDataByNickNameBetween(nickname string, from time.Time, to time.Time) (entrys []*DataEntry, first int, err error) {
+	offsetToSecondIndex, err := m.firstIndex.FindOffsetByNickName(nickname)
+	if err != nil {
+		return nil, 0, err
+	}
+	offsetsToData, err := m.secondIndex.ReadEntryAt(offsetToSecondIndex)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Number of the first entry with time not before t.
+	lowerBound := func(t time.Time) (int, error) {
+		left := 0
+		right := len(offsetsToData)
+		for left < right {
+			mid := (left + right) / 2
+			data, err := m.data.ReadEntryAt(offsetsToData[mid])
+			if err != nil {
+				return 0, err
+			}
+			if data.Time.Before(t) {
+				left = mid + 1
+			} else {
+				right = mid
+			}
+		}
+		return left, nil
+	}
+
+	first, err = lowerBound(from)
+	if err != nil {
+		return nil, 0, err
+	}
+	last, err := lowerBound(to.Add(time.Second)) // Times are stored with one second precision.
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entrys = make([]*DataEntry, 0, maxInt(last-first, 0))
+	for _, offsetToData := range offsetsToData[first:maxInt(last, first)] {
+		data, err := m.data.ReadEntryAt(offsetToData)
+		if err != nil {
+			return nil, 0, err
+		}
+		entrys = append(entrys, data)
+	}
+	return entrys, first, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Returns at most limit nicknames starting with prefix, in sorted order.
+func (m *MordorLogsDB) FindByNickNamePrefix(prefix string, limit int) ([]string, error) {
+	n, err := m.firstIndex.LowerBound(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	nicknames := make([]string, 0)
+	it := m.firstIndex.IteratorAt(n)
+	for len(nicknames) < limit {
+		nickname, _, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(nickname, prefix) {
+			break
+		}
+		nicknames = append(nicknames, nickname)
+	}
+
+	if len(nicknames) == 0 {
+		return nil, ErrEntryNotFound
+	}
+	return nicknames, nil
+}
+
+// Case-insensitive FindByNickNamePrefix, nicknames are sorted ignoring case.
+func (m *MordorLogsDB) FindByNickNamePrefixFold(prefix string, limit int) ([]string, error) {
+	if m.foldedIndex == nil {
+		return nil, ErrIndexNotFound
+	}
+	prefix = foldNickName(prefix)
+
+	left := 0
+	right := m.foldedIndex.GetEntryCount()
+	for left < right {
+		mid := (left + right) / 2
+		nickname, err := m.readFoldedIndexNickName(mid)
+		if err != nil {
+			return nil, err
+		}
+		if foldNickName(nickname) < prefix {
+			left = mid + 1
+		} else {
+			right = mid
+		}
+	}
+
+	nicknames := make([]string, 0)
+	for n := left; n < m.foldedIndex.GetEntryCount() && len(nicknames) < limit; n++ {
+		nickname, err := m.readFoldedIndexNickName(n)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(foldNickName(nickname), prefix) {
+			break
+		}
+		nicknames = append(nicknames, nickname)
+	}
+
+	if len(nicknames) == 0 {
+		return nil, ErrEntryNotFound
+	}
+	return nicknames, nil
+}
+
+func (m *MordorLogsDB) readFoldedIndexNickName(n int) (string, error) {
+	nicknameID, err := m.foldedIndex.ReadEntry(n)
+	if err != nil {
+		return "", err
+	}
+	return m.GetNickNameByID(nicknameID)
+}
+
 // Iterates over all elements. Used in the early stages of converting logs to a database.
 func (m *MordorLogsDB) FindAllDataByNickName(nickname string) ([]*DataEntry, error) {
 	offsetsToSecondIndex, err := m.firstIndex.FindAllOffsetsByNickName(nickname)
@@ -206,6 +512,153 @@ func (m *MordorLogsDB) FindAllDataByNickName(nickname string) ([]*DataEntry, err
 	return allentrys, nil
 }
 
+func (m *MordorLogsDB) GetNickNameByID(nicknameID uint32) (string, error) {
+	nickname, _, err := m.firstIndex.ReadEntry(int(nicknameID))
+	return nickname, err
+}
+
+func (m *MordorLogsDB) FindNickNamesByIP(ip net.IP) ([]string, error) {
+	return m.FindNickNamesByIPOnServer(ip, "")
+}
+
+// The same as FindNickNamesByIP, but only entrys from the game server are used. Empty server means any server.
+func (m *MordorLogsDB) FindNickNamesByIPOnServer(ip net.IP, server string) ([]string, error) {
+	if m.ipIndex == nil {
+		return nil, ErrIndexNotFound
+	}
+	key, err := ipIndexKey(ip)
+	if err != nil {
+		return nil, err
+	}
+	var match func(data *DataEntry) bool
+	if server != "" {
+		match = func(data *DataEntry) bool { return data.Server == server }
+	}
+	return m.findNickNamesByKey(m.ipIndex, key, match)
+}
+
+// Returns nicknames of all accounts that were used on the device.
+func (m *MordorLogsDB) FindNickNamesByFingerprint(fingerprint string) ([]string, error) {
+	return m.FindNickNamesByFingerprintOnServer(fingerprint, "")
+}
+
+// The same as FindNickNamesByFingerprint, but only entrys from the game server are used. Empty server means any server.
+func (m *MordorLogsDB) FindNickNamesByFingerprintOnServer(fingerprint string, server string) ([]string, error) {
+	if m.fingerprintIndex == nil {
+		return nil, ErrIndexNotFound
+	}
+	key := fingerprintIndexKey(fingerprint)
+	if key == nil {
+		return nil, ErrEntryNotFound
+	}
+	// Skip fingerprints with the same hash.
+	return m.findNickNamesByKey(m.fingerprintIndex, key, func(data *DataEntry) bool {
+		return data.Fingerprint == fingerprint && (server == "" || data.Server == server)
+	})
+}
+
+// Iterates over all entrys with IP addresses inside the subnet, ordered by IP address.
+func (m *MordorLogsDB) FindByIPRange(subnet net.IPNet) (*KeyRangeIterator, error) {
+	if m.ipIndex == nil {
+		return nil, ErrIndexNotFound
+	}
+	first, err := ipIndexKey(subnet.IP.Mask(subnet.Mask))
+	if err != nil {
+		return nil, err
+	}
+	// IPv4 keys are IPv4-mapped, so the mask is extended to the whole key.
+	ones, bits := subnet.Mask.Size()
+	if bits == 8*net.IPv4len {
+		ones += 8 * (net.IPv6len - net.IPv4len)
+	} else if bits != 8*net.IPv6len {
+		return nil, ErrInvalidIP
+	}
+	mask := net.CIDRMask(ones, 8*net.IPv6len)
+	last := make([]byte, ipIndexKeySize)
+	for i := range last {
+		last[i] = first[i] | ^mask[i]
+	}
+	return m.findByKeyRange(m.ipIndex, first, last)
+}
+
+// Iterates over all entrys with time from..to inclusive, ordered by time.
+func (m *MordorLogsDB) FindByTimeRange(from time.Time, to time.Time) (*KeyRangeIterator, error) {
+	if m.timeIndex == nil {
+		return nil, ErrIndexNotFound
+	}
+	return m.findByKeyRange(m.timeIndex, timeIndexKey(from), timeIndexKey(to))
+}
+
+// Iterates over all entrys from the game server with time from..to inclusive, ordered by time.
+func (m *MordorLogsDB) FindByServer(server string, from time.Time, to time.Time) (*KeyRangeIterator, error) {
+	if m.serverIndex == nil {
+		return nil, ErrIndexNotFound
+	}
+	it, err := m.findByKeyRange(m.serverIndex, serverIndexKey(server, from), serverIndexKey(server, to))
+	if err != nil {
+		return nil, err
+	}
+	// Skip servers with the same hash.
+	return it.FilterByServer(server), nil
+}
+
+func (m *MordorLogsDB) findByKeyRange(index *KeyIndexFile, first []byte, last []byte) (*KeyRangeIterator, error) {
+	n, err := index.LowerBound(first)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyRangeIterator{db: m, it: index.IteratorAt(n), last: last}, nil
+}
+
+// Returns unique nicknames of all entrys with the key, in sorted order.
+// If match is not nil, only nicknames having at least one matching entry are returned.
+func (m *MordorLogsDB) findNickNamesByKey(index *KeyIndexFile, key []byte, match func(data *DataEntry) bool) ([]string, error) {
+	n, err := index.LowerBound(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nicknames := make([]string, 0)
+	lastNickNameID := int64(-1)
+	it := index.IteratorAt(n)
+	for {
+		entryKey, nicknameID, dataOffset, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(entryKey, key) {
+			break
+		}
+		// Entrys with equal keys are sorted by nickname ID.
+		if int64(nicknameID) == lastNickNameID {
+			continue
+		}
+		if match != nil {
+			data, err := m.data.ReadEntryAt(dataOffset)
+			if err != nil {
+				return nil, err
+			}
+			if !match(data) {
+				continue
+			}
+		}
+		lastNickNameID = int64(nicknameID)
+
+		nickname, err := m.GetNickNameByID(nicknameID)
+		if err != nil {
+			return nil, err
+		}
+		nicknames = append(nicknames, nickname)
+	}
+
+	if len(nicknames) == 0 {
+		return nil, ErrEntryNotFound
+	}
+	return nicknames, nil
+}
+
 func (m *MordorLogsDB) ReadDataEntryAt(offset uint64) (*DataEntry, error) {
 	return m.data.ReadEntryAt(offset)
 }
@@ -226,6 +679,30 @@ func (m *MordorLogsDB) SecondIndexIterator() *SecondIndexIterator {
 	return m.secondIndex.Iterator()
 }
 
+// Opens an existing database and maps first_index.bin, second_index.bin and data.bin into memory,
+// so lookups don't need a system call per field. The mapped database is read-only.
+func NewMappedMordorLogsDB(dirPath string) (*MordorLogsDB, error) {
+	mldb, err := OpenReadOnly(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, mapFile := range []func() error{mldb.firstIndex.Map, mldb.secondIndex.Map, mldb.data.Map} {
+		if err := mapFile(); err != nil {
+			mldb.Close()
+			return nil, err
+		}
+	}
+	return mldb, nil
+}
+
+func OpenReadOnly(dirPath string) (*MordorLogsDB, error) {
+	mldb := new(MordorLogsDB)
+	if err := mldb.OpenReadOnly(dirPath); err != nil {
+		return nil, err
+	}
+	return mldb, nil
+}
+
 func NewMordorLogsDB(dirPath string) (*MordorLogsDB, bool, error) {
 	mldb := new(MordorLogsDB)
 	isnew, err := mldb.Open(dirPath)
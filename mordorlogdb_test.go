@@ -0,0 +1,251 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindByNickNamePrefix(t *testing.T) {
+	mldb, remove := openTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	tests := []struct {
+		prefix string
+		fold   bool
+		limit  int
+		want   []string
+		err    error
+	}{
+		{"", false, 3, []string{"Alice", "John_Smith", "Mike_Tyson"}, nil},
+		{"J", false, 10, []string{"John_Smith"}, nil},
+		{"john", false, 10, []string{"john_doe"}, nil},
+		{"[ABC]_", false, 10, []string{"[ABC]_Bob", "[ABC]_Carl"}, nil},
+		{"[ABC]_", false, 1, []string{"[ABC]_Bob"}, nil},
+		{"Mike_Tyson", false, 10, []string{"Mike_Tyson"}, nil},
+		{"Mike_Tyson_", false, 10, nil, ErrEntryNotFound},
+		{"mike", false, 10, nil, ErrEntryNotFound},
+		{"JOHN", true, 10, []string{"john_doe", "John_Smith"}, nil},
+		{"john_s", true, 10, []string{"John_Smith"}, nil},
+		{"[abc]_c", true, 10, []string{"[ABC]_Carl"}, nil},
+		{"MIKE_TYSON", true, 10, []string{"Mike_Tyson"}, nil},
+		{"", true, 3, []string{"[ABC]_Bob", "[ABC]_Carl", "Alice"}, nil},
+		{"zz", true, 10, nil, ErrEntryNotFound},
+	}
+	for _, test := range tests {
+		find := mldb.FindByNickNamePrefix
+		if test.fold {
+			find = mldb.FindByNickNamePrefixFold
+		}
+		got, err := find(test.prefix, test.limit)
+		if err != test.err || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q (fold %v): got %q, %v, want %q, %v", test.prefix, test.fold, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestFindDataByNickNameBetween(t *testing.T) {
+	mldb, remove := openTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	tests := []struct {
+		from, to string
+		first    int
+		want     []string
+	}{
+		{"01.08.2020 00:00:00", "31.08.2020 23:59:59", 0, []string{"02.08.2020 06:26:11", "02.08.2020 19:55:11", "05.08.2020 12:00:00"}},
+		{"02.08.2020 00:00:00", "02.08.2020 23:59:59", 0, []string{"02.08.2020 06:26:11", "02.08.2020 19:55:11"}},
+		{"02.08.2020 06:26:11", "02.08.2020 06:26:11", 0, []string{"02.08.2020 06:26:11"}},
+		{"02.08.2020 06:26:12", "05.08.2020 12:00:00", 1, []string{"02.08.2020 19:55:11", "05.08.2020 12:00:00"}},
+		{"03.08.2020 00:00:00", "04.08.2020 23:59:59", 2, []string{}},
+		{"06.08.2020 00:00:00", "07.08.2020 00:00:00", 3, []string{}},
+		{"05.08.2020 00:00:00", "01.08.2020 00:00:00", 2, []string{}},
+	}
+	for _, test := range tests {
+		from, err := time.ParseInLocation(timeFormatLayout, test.from, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to, err := time.ParseInLocation(timeFormatLayout, test.to, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entrys, first, err := mldb.FindDataByNickNameBetween("Mike_Tyson", from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, len(entrys))
+		for i, data := range entrys {
+			got[i] = data.Time.UTC().Format(timeFormatLayout)
+		}
+		if first != test.first || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s - %s: got %d %q, want %d %q", test.from, test.to, first, got, test.first, test.want)
+		}
+	}
+
+	if _, _, err := mldb.FindDataByNickNameBetween("Nobody", time.Unix(0, 0), time.Now()); err != ErrEntryNotFound {
+		t.Errorf("unknown nickname: %v, want ErrEntryNotFound", err)
+	}
+}
+
+func TestNewMappedMordorLogsDB(t *testing.T) {
+	dbPath, remove := buildTestDatabase(t, sampleTestLogs)
+	defer remove()
+	plain, _, err := NewMordorLogsDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	mapped, err := NewMappedMordorLogsDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapped.Close()
+
+	plainIt, mappedIt := plain.FirstIndexIterator(), mapped.FirstIndexIterator()
+	for {
+		nickname, offset, err := plainIt.Next()
+		mappedNickName, mappedOffset, mappedErr := mappedIt.Next()
+		if mappedNickName != nickname || mappedOffset != offset || mappedErr != err {
+			t.Fatalf("mapped first index returned %q %d %v, want %q %d %v", mappedNickName, mappedOffset, mappedErr, nickname, offset, err)
+		}
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := plain.FindDataByNickName(nickname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := mapped.FindDataByNickName(nickname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d mapped entrys, want %d", nickname, len(got), len(want))
+		}
+		for i := range got {
+			if !got[i].Equal(want[i]) {
+				t.Errorf("%s: mapped entry %d is %v, want %v", nickname, i, got[i], want[i])
+			}
+		}
+	}
+
+	if _, err := mapped.FindDataByNickName("Nobody"); err != ErrEntryNotFound {
+		t.Errorf("unknown nickname: %v, want ErrEntryNotFound", err)
+	}
+	if err := mapped.Write("Nobody", DataEntry{Time: time.Unix(0, 0)}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("writing to a mapped database returned %v", err)
+	}
+}
+
+// Returns the contents of every file inside the directory.
+func readTestFiles(t *testing.T, dirPath string) map[string]string {
+	files := make(map[string]string)
+	for _, name := range readTestDir(t, dirPath) {
+		b, err := ioutil.ReadFile(filepath.Join(dirPath, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = string(b)
+	}
+	return files
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dbPath, remove := buildTestDatabase(t, sampleTestLogs)
+	defer remove()
+	parentPath := filepath.Dir(dbPath)
+
+	// Missing databases and files are not created.
+	missingPath := filepath.Join(parentPath, "missing")
+	if _, err := OpenReadOnly(missingPath); err == nil {
+		t.Error("opened a missing directory")
+	}
+	if _, err := os.Stat(missingPath); !os.IsNotExist(err) {
+		t.Errorf("missing directory: stat returned %v", err)
+	}
+	for _, name := range []string{"first_index.bin", "second_index.bin", "data.bin"} {
+		damagedPath := filepath.Join(parentPath, "without-"+name)
+		if err := os.Mkdir(damagedPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for fileName, b := range readTestFiles(t, dbPath) {
+			if fileName != name {
+				if err := ioutil.WriteFile(filepath.Join(damagedPath, fileName), []byte(b), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		before := readTestDir(t, damagedPath)
+		if mldb, err := OpenReadOnly(damagedPath); err == nil {
+			mldb.Close()
+			t.Errorf("opened a database without %s", name)
+		}
+		if after := readTestDir(t, damagedPath); !reflect.DeepEqual(after, before) {
+			t.Errorf("without %s: files %v became %v", name, before, after)
+		}
+	}
+
+	before := readTestFiles(t, dbPath)
+	for _, bulk := range []bool{false, true} {
+		mldb, err := OpenReadOnly(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bulk {
+			mldb.EnableBulkWrite(1 << 10)
+		}
+		data := DataEntry{Time: time.Unix(0, 0), IP: []byte{1, 1, 1, 1}}
+		writes := []struct {
+			name  string
+			write func() error
+		}{
+			{"Write", func() error { return mldb.Write("Nobody", data) }},
+			{"WriteAll", func() error { return mldb.WriteAll("Nobody", []*DataEntry{&data}) }},
+			{"DataFile.WriteEntry", func() error { _, err := mldb.data.WriteEntry("Nobody", data); return err }},
+			{"FirstIndexFile.WriteEntry", func() error { _, err := mldb.firstIndex.WriteEntry("Nobody", 0); return err }},
+			{"SecondIndexFile.WriteEntry", func() error { _, err := mldb.secondIndex.WriteEntry([]uint64{0}); return err }},
+			{"KeyIndexFile.WriteEntry", func() error { return mldb.ipIndex.WriteEntry([]byte{1, 1, 1, 1}, 0, 0) }},
+			{"FoldedIndexFile.WriteEntry", func() error { return mldb.foldedIndex.WriteEntry(0) }},
+		}
+		for _, test := range writes {
+			if err := test.write(); err != ErrReadOnly {
+				t.Errorf("%s (bulk %v): error %v, want ErrReadOnly", test.name, bulk, err)
+			}
+		}
+		if err := mldb.Flush(); err != nil {
+			t.Errorf("bulk %v: flush error %v", bulk, err)
+		}
+		if err := mldb.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after := readTestFiles(t, dbPath); !reflect.DeepEqual(after, before) {
+		t.Error("files of the database changed while it was opened read-only")
+	}
+}
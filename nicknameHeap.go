@@ -0,0 +1,157 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+/* Nickname heap file: Nicknames of the first index one after another, without separators.
+Offsets and lengths of the nicknames are kept in the first index entrys.
+*/
+
+var nicknameHeapHeaderMarker = [16]byte{'M', 'o', 'r', 'd', 'o', 'r', 'L', 'o', 'g', 's', 'D', 'B', 0x0a, 0x0a, 0x0a, 0x0a}
+
+// The heap is stored next to the first index: first_index.bin => first_index_nicknames.bin
+func nicknameHeapPath(firstIndexPath string) string {
+	return strings.TrimSuffix(firstIndexPath, ".bin") + "_nicknames.bin"
+}
+
+type NickNameHeapFile struct {
+	file        *os.File
+	writeOffset int64
+	mapped      []byte // Set by Map, reads are served from it instead of the file.
+	bulk        *bulkWriter
+	readOnly    bool
+}
+
+func (m *NickNameHeapFile) Open(filePath string) (isnew bool, err error) {
+	m.file, err = os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0755)
+	if err != nil {
+		return false, err
+	}
+	stat, err := m.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	fileSize := stat.Size()
+	if fileSize == 0 {
+		if err := m.writeHeader(); err != nil {
+			return true, err
+		}
+		m.writeOffset = fileHeaderSize
+		return true, nil
+	} else {
+		if err := m.readHeader(); err != nil {
+			return false, err
+		}
+		m.writeOffset = fileSize
+	}
+	return false, err
+}
+
+// Opens an existing file for reading only, writes return ErrReadOnly.
+func (m *NickNameHeapFile) OpenReadOnly(filePath string) (err error) {
+	if m.file, m.writeOffset, err = openFileReadOnly(filePath, nicknameHeapHeaderMarker); err != nil {
+		return err
+	}
+	m.readOnly = true
+	return nil
+}
+
+func (m *NickNameHeapFile) Close() error {
+	if err := flushBulkWriter(m.bulk); err != nil {
+		m.file.Close()
+		return err
+	}
+	if m.mapped != nil {
+		if err := unmapFile(m.mapped); err != nil {
+			return err
+		}
+		m.mapped = nil
+	}
+	return m.file.Close()
+}
+
+// Maps the file into memory. Nothing can be written to a mapped file.
+func (m *NickNameHeapFile) Map() (err error) {
+	m.mapped, err = mapFile(m.file, m.writeOffset)
+	m.readOnly = true
+	return err
+}
+
+func (m *NickNameHeapFile) Sync() error {
+	if err := m.Flush(); err != nil {
+		return err
+	}
+	return m.file.Sync()
+}
+
+// Buffers written nicknames in memory, up to bufferSize bytes. They can't be read before Flush.
+func (m *NickNameHeapFile) EnableBulkWrite(bufferSize int) {
+	m.bulk = newBulkWriter(m.file, bufferSize)
+}
+
+func (m *NickNameHeapFile) Flush() error {
+	return flushBulkWriter(m.bulk)
+}
+
+func (m *NickNameHeapFile) readHeader() error {
+	fh := NewFileHeader(nicknameHeapHeaderMarker)
+	if err := fh.readHeaderFromFile(m.file); err != nil {
+		return err
+	}
+	if !fh.checkVersion() {
+		return ErrIncompatibleVersions
+	}
+	return nil
+}
+
+func (m *NickNameHeapFile) writeHeader() error {
+	fh := NewFileHeader(nicknameHeapHeaderMarker)
+	if err := fh.writeHeaderToFile(m.file); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Returns the offset of the nickname in the heap.
+func (m *NickNameHeapFile) WriteNickName(nickname string) (uint64, error) {
+	if m.readOnly {
+		return 0, ErrReadOnly
+	}
+	returnOffset := uint64(m.writeOffset - fileHeaderSize)
+	if err := writeAt(m.file, m.bulk, []byte(nickname), m.writeOffset); err != nil {
+		return 0, err
+	}
+	m.writeOffset += int64(len(nickname))
+	return returnOffset, nil
+}
+
+func (m *NickNameHeapFile) ReadNickName(offset uint64, length int) (string, error) {
+	if int64(offset)+int64(length) > m.writeOffset-fileHeaderSize {
+		return "", ErrCorrupted
+	}
+	b, err := readBytesAt(m.file, m.mapped, fileHeaderSize+int64(offset), length)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,124 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNickNames = []string{
+	"Mike_Tyson",
+	"",
+	"[ABC]_Bob",
+	"Вася_Пупкин",
+	strings.Repeat("N", MaxNickNameLength),
+	"x",
+}
+
+func TestNickNameHeapFile(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "mordorlogs-test-heap-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	for _, bulk := range []bool{false, true} {
+		filePath := filepath.Join(dirPath, "first_index_nicknames.bin")
+		os.Remove(filePath)
+
+		var heap NickNameHeapFile
+		if isnew, err := heap.Open(filePath); err != nil || !isnew {
+			t.Fatalf("Open: %v, %v", isnew, err)
+		}
+		if bulk {
+			heap.EnableBulkWrite(16)
+		}
+		offsets := make([]uint64, len(testNickNames))
+		for i, nickname := range testNickNames {
+			if offsets[i], err = heap.WriteNickName(nickname); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := heap.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := heap.OpenReadOnly(filePath); err != nil {
+			t.Fatal(err)
+		}
+		for i, nickname := range testNickNames {
+			if got, err := heap.ReadNickName(offsets[i], len(nickname)); err != nil || got != nickname {
+				t.Errorf("bulk %v: nickname %d is %q, %v, want %q", bulk, i, got, err, nickname)
+			}
+		}
+		size := uint64(heap.writeOffset - fileHeaderSize)
+		if _, err := heap.ReadNickName(size-1, 2); err != ErrCorrupted {
+			t.Errorf("bulk %v: read past the end: %v", bulk, err)
+		}
+		if _, err := heap.WriteNickName("Bob"); err != ErrReadOnly {
+			t.Errorf("bulk %v: write error %v, want ErrReadOnly", bulk, err)
+		}
+		heap.Close()
+	}
+}
+
+func TestLongNickNames(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "mordorlogs-test-long-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	mldb, isnew, err := NewMordorLogsDB(dirPath)
+	if err != nil || !isnew {
+		t.Fatalf("NewMordorLogsDB: %v, %v", isnew, err)
+	}
+	data := DataEntry{Time: time.Unix(1596315888, 0), IP: net.ParseIP("10.0.2.4")}
+	for _, nickname := range testNickNames[2:] {
+		if err := mldb.Write(nickname, data); err != nil {
+			t.Fatalf("%q: %v", nickname, err)
+		}
+	}
+	if err := mldb.Write(strings.Repeat("N", MaxNickNameLength+1), data); err != ErrLongNickName {
+		t.Errorf("nickname of %d bytes: %v, want ErrLongNickName", MaxNickNameLength+1, err)
+	}
+	if err := closeDatabase(mldb); err != nil {
+		t.Fatal(err)
+	}
+
+	if mldb, err = OpenReadOnly(dirPath); err != nil {
+		t.Fatal(err)
+	}
+	defer mldb.Close()
+	for _, nickname := range testNickNames[2:] {
+		entrys, err := mldb.NoBinaryFindDataByNickName(nickname)
+		if err != nil || len(entrys) != 1 || !entrys[0].Equal(&data) {
+			t.Errorf("%q: got %v, %v", nickname, entrys, err)
+		}
+	}
+	if _, err := mldb.NoBinaryFindDataByNickName(strings.Repeat("N", MaxNickNameLength-1)); err != ErrEntryNotFound {
+		t.Errorf("prefix of a long nickname: %v, want ErrEntryNotFound", err)
+	}
+}
@@ -0,0 +1,359 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/* Query: Conditions on entry fields joined by AND, for example:
+	ip=1.2.3.0/24 AND brand=Xiaomi AND time>=01.08.2020
+	nick=[ABC]_* AND server=1.1.1.1:7777 AND time<01.08.2020 21:00
+Fields:
+	nick				= Exact nickname or wildcard pattern, see CompileNickNamePattern.
+	ip					= IPv4 or IPv6 address or subnet.
+	fingerprint, server	= Exact value.
+	android, brand, model	= Value ignoring case.
+	time				= 01.08.2020, 01.08.2020 21:00 or 01.08.2020 21:00:05, UTC.
+Every field supports "=" and "!=", time also supports "<", "<=", ">" and ">=".
+A time value means the whole day, minute or second: time=01.08.2020 matches the whole day,
+time>01.08.2020 starts from the next day.
+*/
+
+type Query struct {
+	conditions []queryCondition
+}
+
+type queryCondition struct {
+	field string
+	op    string
+	value string
+
+	nickname *NickNamePattern
+	subnet   *net.IPNet
+	from, to time.Time // Time interval of the value, both included.
+}
+
+// Anything that returns entrys one by one together with their nicknames.
+type EntryIterator interface {
+	Next() (string, *DataEntry, error)
+}
+
+var queryAndSeparator = regexp.MustCompile(`(?i)\s+AND\s+`)
+
+var queryConditionPattern = regexp.MustCompile(`^([A-Za-z]+)\s*(!=|>=|<=|=|>|<)\s*(.+)$`)
+
+var queryTimeLayouts = []struct {
+	layout string
+	length time.Duration
+}{
+	{timeFormatLayout, time.Second},
+	{dateFormatLayout + " 15:04", time.Minute},
+	{dateFormatLayout, 24 * time.Hour},
+}
+
+func ParseQuery(expr string) (*Query, error) {
+	q := new(Query)
+	for _, term := range queryAndSeparator.Split(strings.TrimSpace(expr), -1) {
+		match := queryConditionPattern.FindStringSubmatch(term)
+		if match == nil {
+			return nil, fmt.Errorf("%w: %q is not a condition", ErrInvalidQuery, term)
+		}
+		cond, err := parseQueryCondition(strings.ToLower(match[1]), match[2], strings.TrimSpace(match[3]))
+		if err != nil {
+			return nil, err
+		}
+		q.conditions = append(q.conditions, cond)
+	}
+	return q, nil
+}
+
+func parseQueryCondition(field string, op string, value string) (cond queryCondition, err error) {
+	cond = queryCondition{field: field, op: op, value: value}
+	if op != "=" && op != "!=" && field != "time" {
+		return cond, fmt.Errorf("%w: %s can only be compared with = and !=", ErrInvalidQuery, field)
+	}
+
+	switch field {
+	case "nick":
+		if cond.nickname, err = CompileNickNamePattern(value); err != nil {
+			return cond, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+	case "ip":
+		if !strings.Contains(value, "/") {
+			if strings.Contains(value, ":") {
+				value += "/128"
+			} else {
+				value += "/32"
+			}
+		}
+		_, subnet, err := net.ParseCIDR(value)
+		if err != nil {
+			return cond, fmt.Errorf("%w: %q is not an IP address or subnet", ErrInvalidQuery, cond.value)
+		}
+		cond.subnet = subnet
+	case "time":
+		for _, t := range queryTimeLayouts {
+			if from, err := time.ParseInLocation(t.layout, value, time.UTC); err == nil {
+				cond.from, cond.to = from, from.Add(t.length-time.Second)
+				return cond, nil
+			}
+		}
+		return cond, fmt.Errorf("%w: %q is not a time", ErrInvalidQuery, value)
+	case "fingerprint", "server", "android", "brand", "model":
+	default:
+		return cond, fmt.Errorf("%w: unknown field %s", ErrInvalidQuery, field)
+	}
+	return cond, nil
+}
+
+func (m *queryCondition) match(nickname string, data *DataEntry) bool {
+	var equal bool
+	switch m.field {
+	case "nick":
+		equal = m.nickname.MatchString(nickname)
+	case "ip":
+		equal = m.subnet.Contains(data.IP)
+	case "fingerprint":
+		equal = data.Fingerprint == m.value
+	case "server":
+		equal = data.Server == m.value
+	case "android":
+		equal = strings.EqualFold(data.Android, m.value)
+	case "brand":
+		equal = strings.EqualFold(data.Brand, m.value)
+	case "model":
+		equal = strings.EqualFold(data.Model, m.value)
+	case "time":
+		switch m.op {
+		case "<":
+			return data.Time.Before(m.from)
+		case "<=":
+			return !data.Time.After(m.to)
+		case ">":
+			return data.Time.After(m.to)
+		case ">=":
+			return !data.Time.Before(m.from)
+		}
+		equal = !data.Time.Before(m.from) && !data.Time.After(m.to)
+	}
+	return equal == (m.op == "=")
+}
+
+func (m *Query) Match(nickname string, data *DataEntry) bool {
+	for i := range m.conditions {
+		if !m.conditions[i].match(nickname, data) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the time interval every matching entry is inside of, ok is false if the time is not limited.
+func (m *Query) timeBounds() (from time.Time, to time.Time, ok bool) {
+	from, to = time.Unix(0, 0).UTC(), time.Unix(1<<62, 0).UTC()
+	for _, cond := range m.conditions {
+		if cond.field != "time" {
+			continue
+		}
+		switch cond.op {
+		case "<":
+			to = minTime(to, cond.from.Add(-time.Second))
+		case "<=":
+			to = minTime(to, cond.to)
+		case ">":
+			from = maxTime(from, cond.to.Add(time.Second))
+		case ">=":
+			from = maxTime(from, cond.from)
+		case "=":
+			from, to = maxTime(from, cond.from), minTime(to, cond.to)
+		default:
+			continue
+		}
+		ok = true
+	}
+	return from, to, ok
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// Streams entrys matching the query. The plan is a short description of how the entrys are found.
+type QueryIterator struct {
+	query    *Query
+	source   EntryIterator
+	plan     string
+	deadline time.Time
+}
+
+func (m *QueryIterator) GetPlan() string {
+	return m.plan
+}
+
+// Next returns ErrQueryTimeout once the deadline has passed, even if the entrys read since don't match.
+func (m *QueryIterator) SetDeadline(deadline time.Time) {
+	m.deadline = deadline
+}
+
+func (m *QueryIterator) Next() (string, *DataEntry, error) {
+	for {
+		if !m.deadline.IsZero() && time.Now().After(m.deadline) {
+			return "", nil, ErrQueryTimeout
+		}
+		nickname, data, err := m.source.Next()
+		if err != nil {
+			return "", nil, err
+		}
+		if m.query.Match(nickname, data) {
+			return nickname, data, nil
+		}
+	}
+}
+
+// Picks the first usable way to find the entrys: nicknames by prefix, fingerprint, IP address,
+// server or time index; without one every entry of the database is scanned.
+// Indexes missing from the database are skipped.
+func (m *MordorLogsDB) RunQuery(q *Query) (*QueryIterator, error) {
+	it := &QueryIterator{query: q}
+	from, to, timeLimited := q.timeBounds()
+	if from.After(to) {
+		it.source, it.plan = emptyEntryIterator{}, "empty time range"
+		return it, nil
+	}
+
+	plans := []func(cond *queryCondition) (EntryIterator, string, error){
+		func(cond *queryCondition) (EntryIterator, string, error) {
+			if cond.field != "nick" || cond.nickname.GetPrefix() == "" {
+				return nil, "", nil
+			}
+			source, err := m.scanNickNames(cond.nickname.GetPrefix())
+			return source, "first_index.bin prefix " + cond.nickname.GetPrefix(), err
+		},
+		func(cond *queryCondition) (EntryIterator, string, error) {
+			if cond.field != "fingerprint" || m.fingerprintIndex == nil || cond.value == "" {
+				return nil, "", nil
+			}
+			key := fingerprintIndexKey(cond.value)
+			source, err := m.findByKeyRange(m.fingerprintIndex, key, key)
+			return source, "fingerprint_index.bin " + cond.value, err
+		},
+		func(cond *queryCondition) (EntryIterator, string, error) {
+			if cond.field != "ip" || m.ipIndex == nil {
+				return nil, "", nil
+			}
+			source, err := m.FindByIPRange(*cond.subnet)
+			return source, "ip_index.bin " + cond.subnet.String(), err
+		},
+		func(cond *queryCondition) (EntryIterator, string, error) {
+			if cond.field != "server" || m.serverIndex == nil {
+				return nil, "", nil
+			}
+			source, err := m.FindByServer(cond.value, from, to)
+			return source, "server_index.bin " + cond.value, err
+		},
+	}
+	for _, plan := range plans {
+		for i := range q.conditions {
+			if q.conditions[i].op != "=" {
+				continue
+			}
+			source, name, err := plan(&q.conditions[i])
+			if err != nil {
+				return nil, err
+			}
+			if source != nil {
+				it.source, it.plan = source, name
+				return it, nil
+			}
+		}
+	}
+
+	if timeLimited && m.timeIndex != nil {
+		source, err := m.FindByTimeRange(from, to)
+		if err != nil {
+			return nil, err
+		}
+		it.source, it.plan = source, "time_index.bin"
+		return it, nil
+	}
+
+	source, err := m.scanNickNames("")
+	if err != nil {
+		return nil, err
+	}
+	it.source, it.plan = source, "full scan"
+	return it, nil
+}
+
+// Returns all entrys of nicknames starting with the prefix, nickname by nickname.
+func (m *MordorLogsDB) scanNickNames(prefix string) (*nicknameScanIterator, error) {
+	n := 0
+	if prefix != "" {
+		var err error
+		if n, err = m.firstIndex.LowerBound(prefix); err != nil {
+			return nil, err
+		}
+	}
+	it := &DataIterator{firstIndexIterator: m.firstIndex.IteratorAt(n), db: m, unique: true}
+	return &nicknameScanIterator{it: it, prefix: prefix}, nil
+}
+
+type nicknameScanIterator struct {
+	it       *DataIterator
+	prefix   string
+	nickname string
+	entrys   []*DataEntry
+}
+
+func (m *nicknameScanIterator) Next() (string, *DataEntry, error) {
+	for len(m.entrys) == 0 {
+		nickname, entrys, err := m.it.Next()
+		if err != nil {
+			return "", nil, err
+		}
+		if !strings.HasPrefix(nickname, m.prefix) {
+			return "", nil, ErrIterationDone
+		}
+		m.nickname, m.entrys = nickname, entrys
+	}
+	data := m.entrys[0]
+	m.entrys = m.entrys[1:]
+	return m.nickname, data, nil
+}
+
+type emptyEntryIterator struct{}
+
+func (emptyEntryIterator) Next() (string, *DataEntry, error) {
+	return "", nil, ErrIterationDone
+}
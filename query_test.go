@@ -0,0 +1,253 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"nick=Mike_Tyson", true},
+		{"NICK=Mike_Tyson AND IP=10.0.0.0/8", true},
+		{"ip=1.2.3.0/24 and brand=Xiaomi AND time>=01.08.2020", true},
+		{"ip=2001:db8::1", true},
+		{"time<01.08.2020 21:00", true},
+		{"time!=01.08.2020 21:00:05", true},
+		{"nick=re:^Mike_", true},
+		{"server != 1.1.1.1:7777", true},
+		{"brand>Xiaomi", false},
+		{"ip=1.2.3", false},
+		{"time=2020-08-01", false},
+		{"color=red", false},
+		{"nick", false},
+		{"nick=re:[", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		_, err := ParseQuery(test.expr)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: error %v", test.expr, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%q: %v is not ErrInvalidQuery", test.expr, err)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	data := &DataEntry{
+		Time:        time.Date(2020, 8, 1, 21, 4, 48, 0, time.UTC),
+		IP:          net.ParseIP("10.0.2.4"),
+		Android:     "10",
+		Brand:       "Xiaomi",
+		Model:       "Mi 10",
+		Fingerprint: "fp/dev4",
+		Server:      "1.1.1.1:7777",
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"nick=Mike_Tyson", true},
+		{"nick=Mike_*", true},
+		{"nick!=Mike_Tyson", false},
+		{"ip=10.0.0.0/16", true},
+		{"ip=10.0.2.4", true},
+		{"ip=10.0.2.5", false},
+		{"ip=::ffff:10.0.2.4", true},
+		{"brand=XIAOMI AND model=mi 10", true},
+		{"fingerprint=FP/DEV4", false},
+		{"server=1.1.1.1:7777 AND android!=11", true},
+		{"time=01.08.2020", true},
+		{"time=01.08.2020 21:04", true},
+		{"time=01.08.2020 21:04:49", false},
+		{"time<01.08.2020", false},
+		{"time<=01.08.2020", true},
+		{"time>01.08.2020", false},
+		{"time>31.07.2020", true},
+		{"time>=01.08.2020 21:04:48", true},
+		{"time>01.08.2020 21:04:48", false},
+		{"time!=02.08.2020", true},
+		{"ip=10.0.0.0/8 AND brand=Samsung", false},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", test.expr, err)
+		}
+		if got := q.Match("Mike_Tyson", data); got != test.want {
+			t.Errorf("%q: Match = %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestQueryTimeBounds(t *testing.T) {
+	tests := []struct {
+		expr     string
+		from, to string
+		ok       bool
+	}{
+		{"brand=Xiaomi", "", "", false},
+		{"time!=01.08.2020", "", "", false},
+		{"time=01.08.2020", "01.08.2020 00:00:00", "01.08.2020 23:59:59", true},
+		{"time>=01.08.2020 AND time<03.08.2020", "01.08.2020 00:00:00", "02.08.2020 23:59:59", true},
+		{"time>01.08.2020 21:00 AND time<=01.08.2020 22:00", "01.08.2020 21:01:00", "01.08.2020 22:00:59", true},
+		{"time>=05.08.2020 AND time=01.08.2020", "05.08.2020 00:00:00", "01.08.2020 23:59:59", true},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", test.expr, err)
+		}
+		from, to, ok := q.timeBounds()
+		if ok != test.ok {
+			t.Errorf("%q: ok = %v, want %v", test.expr, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got := from.Format(timeFormatLayout); got != test.from {
+			t.Errorf("%q: from %s, want %s", test.expr, got, test.from)
+		}
+		if got := to.Format(timeFormatLayout); got != test.to {
+			t.Errorf("%q: to %s, want %s", test.expr, got, test.to)
+		}
+	}
+}
+
+func TestRunQuery(t *testing.T) {
+	mldb, remove := openTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	tests := []struct {
+		expr string
+		plan string
+		want []string
+	}{
+		{"nick=[ABC]_*", "first_index.bin prefix [ABC]_", []string{
+			"[ABC]_Bob 02.08.2020 10:00:00 192.168.1.1",
+			"[ABC]_Carl 05.08.2020 11:00:00 192.168.1.1",
+		}},
+		{"NICK=Mike_Tyson AND Time>=05.08.2020", "first_index.bin prefix Mike_Tyson", []string{
+			"Mike_Tyson 05.08.2020 12:00:00 10.0.2.4",
+		}},
+		{"fingerprint=fp/dev4 AND server=2.2.2.2:7777", "fingerprint_index.bin fp/dev4", []string{
+			"Alice 05.08.2020 08:30:00 2001:db8::2",
+			"Mike_Tyson 02.08.2020 06:26:11 10.0.2.4",
+			"Mike_Tyson 05.08.2020 12:00:00 10.0.2.4",
+		}},
+		{"ip=2001:db8::/32", "ip_index.bin 2001:db8::/32", []string{
+			"John_Smith 02.08.2020 21:04:48 2001:db8::1",
+			"Alice 05.08.2020 08:30:00 2001:db8::2",
+		}},
+		{"server=2.2.2.2:7777 AND time=05.08.2020", "server_index.bin 2.2.2.2:7777", []string{
+			"Alice 05.08.2020 08:30:00 2001:db8::2",
+			"Mike_Tyson 05.08.2020 12:00:00 10.0.2.4",
+		}},
+		{"time>05.08.2020 11:00", "time_index.bin", []string{
+			"Mike_Tyson 05.08.2020 12:00:00 10.0.2.4",
+			"john_doe 05.08.2020 23:59:59 172.16.0.1",
+		}},
+		{"time>05.08.2020 AND time<05.08.2020", "empty time range", []string{}},
+		{"brand=xiaomi AND ip!=10.0.0.0/8 AND server=1.1.1.1:7777 AND nick!=john_doe", "server_index.bin 1.1.1.1:7777", []string{
+			"[ABC]_Bob 02.08.2020 10:00:00 192.168.1.1",
+			"John_Smith 02.08.2020 21:04:48 2001:db8::1",
+			"[ABC]_Carl 05.08.2020 11:00:00 192.168.1.1",
+		}},
+		{"android=10 AND nick!=Mike_Tyson", "full scan", []string{
+			"Alice 05.08.2020 08:30:00 2001:db8::2",
+			"John_Smith 02.08.2020 21:04:48 2001:db8::1",
+			"[ABC]_Bob 02.08.2020 10:00:00 192.168.1.1",
+			"[ABC]_Carl 05.08.2020 11:00:00 192.168.1.1",
+			"john_doe 05.08.2020 23:59:59 172.16.0.1",
+		}},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", test.expr, err)
+		}
+		it, err := mldb.RunQuery(q)
+		if err != nil {
+			t.Fatalf("%q: %v", test.expr, err)
+		}
+		if it.GetPlan() != test.plan {
+			t.Errorf("%q: plan %q, want %q", test.expr, it.GetPlan(), test.plan)
+		}
+		if got := readTestEntrys(t, it); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.expr, got, test.want)
+		}
+	}
+}
+
+// Returns entrys one by one and counts how many were read.
+type countingEntryIterator struct {
+	entrys []*DataEntry
+	read   int
+}
+
+func (m *countingEntryIterator) Next() (string, *DataEntry, error) {
+	if m.read == len(m.entrys) {
+		return "", nil, ErrIterationDone
+	}
+	m.read++
+	return "Mike_Tyson", m.entrys[m.read-1], nil
+}
+
+func TestQueryIteratorDeadline(t *testing.T) {
+	q, err := ParseQuery("brand=Samsung")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entrys := make([]*DataEntry, 100)
+	for i := range entrys {
+		entrys[i] = &DataEntry{Brand: "Xiaomi"}
+	}
+
+	tests := []struct {
+		name     string
+		deadline time.Time
+		err      error
+		read     int
+	}{
+		{"no deadline", time.Time{}, ErrIterationDone, len(entrys)},
+		{"future deadline", time.Now().Add(time.Hour), ErrIterationDone, len(entrys)},
+		{"passed deadline", time.Now().Add(-time.Second), ErrQueryTimeout, 0},
+	}
+	for _, test := range tests {
+		source := &countingEntryIterator{entrys: entrys}
+		it := &QueryIterator{query: q, source: source}
+		it.SetDeadline(test.deadline)
+		if _, _, err := it.Next(); err != test.err {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+		}
+		if source.read != test.read {
+			t.Errorf("%s: %d entrys read, want %d", test.name, source.read, test.read)
+		}
+	}
+}
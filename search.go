@@ -0,0 +1,150 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"time"
+)
+
+// Patterns starting with this prefix are regular expressions, the others are wildcards.
+const regexpPatternPrefix = "re:"
+
+// How many nicknames are checked between deadline checks.
+const searchDeadlineCheckInterval = 1024
+
+// Compiled nickname pattern and the literal prefix every matching nickname starts with.
+type NickNamePattern struct {
+	re     *regexp.Regexp
+	prefix string
+}
+
+// Wildcard patterns: '*' matches any characters and '?' matches one character,
+// everything else (including '[' and ']' used in clan tags) is matched literally.
+// Regular expressions use the "re:" prefix and match any part of a nickname unless anchored with '^' and '$'.
+func CompileNickNamePattern(pattern string) (*NickNamePattern, error) {
+	if strings.HasPrefix(pattern, regexpPatternPrefix) {
+		expr := pattern[len(regexpPatternPrefix):]
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		return &NickNamePattern{re: re, prefix: regexpLiteralPrefix(expr)}, nil
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	prefixEnd := -1
+	for i, c := range pattern {
+		switch c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+			continue
+		}
+		if prefixEnd == -1 {
+			prefixEnd = i
+		}
+	}
+	expr.WriteString("$")
+	if prefixEnd == -1 {
+		prefixEnd = len(pattern)
+	}
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, err
+	}
+	return &NickNamePattern{re: re, prefix: pattern[:prefixEnd]}, nil
+}
+
+// Returns the literal text an anchored regular expression starts with, "" if there is none.
+func regexpLiteralPrefix(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+
+	var prefix strings.Builder
+	for _, sub := range re.Sub[1:] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
+		}
+		prefix.WriteString(string(sub.Rune))
+	}
+	return prefix.String()
+}
+
+func (m *NickNamePattern) GetPrefix() string {
+	return m.prefix
+}
+
+func (m *NickNamePattern) MatchString(nickname string) bool {
+	return m.re.MatchString(nickname)
+}
+
+// Returns at most limit matching nicknames in sorted order. Only nicknames starting with the literal
+// prefix of the pattern are scanned; without a prefix the whole first index is scanned.
+// The scan stops after timeout, truncated is true if it was stopped before reaching the end.
+func (m *MordorLogsDB) SearchNickNames(pattern *NickNamePattern, limit int, timeout time.Duration) (nicknames []string, truncated bool, err error) {
+	deadline := time.Now().Add(timeout)
+
+	n := 0
+	if pattern.prefix != "" {
+		if n, err = m.firstIndex.LowerBound(pattern.prefix); err != nil {
+			return nil, false, err
+		}
+	}
+
+	nicknames = make([]string, 0)
+	it := m.firstIndex.IteratorAt(n)
+	for i := 1; ; i++ {
+		nickname, _, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		} else if err != nil {
+			return nil, false, err
+		}
+		if !strings.HasPrefix(nickname, pattern.prefix) {
+			break
+		}
+
+		if pattern.MatchString(nickname) {
+			if len(nicknames) == limit {
+				return nicknames, true, nil
+			}
+			nicknames = append(nicknames, nickname)
+		}
+
+		if i%searchDeadlineCheckInterval == 0 && time.Now().After(deadline) {
+			return nicknames, true, nil
+		}
+	}
+
+	return nicknames, false, nil
+}
@@ -0,0 +1,128 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompileNickNamePattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		prefix   string
+		match    []string
+		notMatch []string
+	}{
+		{"[ABC]_*", "[ABC]_", []string{"[ABC]_Bob", "[ABC]_"}, []string{"A_Bob", "[ABC]", "x[ABC]_Bob"}},
+		{"Mike_?yson", "Mike_", []string{"Mike_Tyson", "Mike_Dyson"}, []string{"Mike_yson", "Mike_TTyson"}},
+		{"*_Tyson", "", []string{"Mike_Tyson", "_Tyson"}, []string{"Mike_Tyson2"}},
+		{"Mike", "Mike", []string{"Mike"}, []string{"Mike_Tyson", "mike"}},
+		{"a.b(c)$", "a.b(c)$", []string{"a.b(c)$"}, []string{"axb(c)$", "a.bc"}},
+		{"Вася_*", "Вася_", []string{"Вася_Пупкин"}, []string{"Вася"}},
+		{"re:^Nick_\\d+$", "Nick_", []string{"Nick_1", "Nick_123"}, []string{"Nick_", "xNick_1", "Nick_1x"}},
+		{"re:Nick", "", []string{"Nick", "xNickx"}, []string{"nick"}},
+		{"re:^(?i)nick", "", []string{"NICK_1", "nick"}, []string{"xnick"}},
+		{"re:^ab|^ac", "", []string{"ab", "acd"}, []string{"ad"}},
+	}
+	for _, test := range tests {
+		pattern, err := CompileNickNamePattern(test.pattern)
+		if err != nil {
+			t.Errorf("%q: %v", test.pattern, err)
+			continue
+		}
+		if pattern.GetPrefix() != test.prefix {
+			t.Errorf("%q: prefix %q, want %q", test.pattern, pattern.GetPrefix(), test.prefix)
+		}
+		for _, nickname := range test.match {
+			if !pattern.MatchString(nickname) {
+				t.Errorf("%q doesn't match %q", test.pattern, nickname)
+			}
+		}
+		for _, nickname := range test.notMatch {
+			if pattern.MatchString(nickname) {
+				t.Errorf("%q matches %q", test.pattern, nickname)
+			}
+		}
+	}
+
+	if _, err := CompileNickNamePattern("re:[a"); err == nil {
+		t.Error("invalid regular expression compiled")
+	}
+}
+
+func TestRegexpLiteralPrefix(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"^abc", "abc"},
+		{"^abc$", "abc"},
+		{"^ab.*c", "ab"},
+		{"^abc?", "ab"},
+		{"^Nick_\\d+", "Nick_"},
+		{"^\\[ABC\\]_", "[ABC]_"},
+		{"abc", ""},
+		{"^", ""},
+		{"^$", ""},
+		{"^.abc", ""},
+		{"^(?i)abc", ""},
+		{"^a+b", ""},
+		{"[", ""},
+	}
+	for _, test := range tests {
+		if got := regexpLiteralPrefix(test.expr); got != test.want {
+			t.Errorf("regexpLiteralPrefix(%q) = %q, want %q", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestSearchNickNames(t *testing.T) {
+	mldb, remove := openTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	tests := []struct {
+		pattern   string
+		limit     int
+		want      []string
+		truncated bool
+	}{
+		{"[ABC]_*", 10, []string{"[ABC]_Bob", "[ABC]_Carl"}, false},
+		{"[ABC]_*", 1, []string{"[ABC]_Bob"}, true},
+		{"*_*", 10, []string{"John_Smith", "Mike_Tyson", "[ABC]_Bob", "[ABC]_Carl", "john_doe"}, false},
+		{"?ohn_*", 10, []string{"John_Smith", "john_doe"}, false},
+		{"re:^Mike_", 10, []string{"Mike_Tyson"}, false},
+		{"re:(?i)^JOHN", 10, []string{"John_Smith", "john_doe"}, false},
+		{"Nobody*", 10, []string{}, false},
+	}
+	for _, test := range tests {
+		pattern, err := CompileNickNamePattern(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, truncated, err := mldb.SearchNickNames(pattern, test.limit, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) || truncated != test.truncated {
+			t.Errorf("%q: got %q, %v, want %q, %v", test.pattern, got, truncated, test.want, test.truncated)
+		}
+	}
+}
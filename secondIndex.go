@@ -35,6 +35,9 @@ var secondIndexHeaderMarker = [16]byte{'M', 'o', 'r', 'd', 'o', 'r', 'L', 'o', '
 type SecondIndexFile struct {
 	file        *os.File
 	writeOffset int64
+	mapped      []byte // Set by Map, reads are served from it instead of the file.
+	bulk        *bulkWriter
+	readOnly    bool
 }
 
 func (m *SecondIndexFile) Open(filePath string) (isnew bool, err error) {
@@ -62,14 +65,52 @@ func (m *SecondIndexFile) Open(filePath string) (isnew bool, err error) {
 	return false, err
 }
 
+// Opens an existing file for reading only, writes return ErrReadOnly.
+func (m *SecondIndexFile) OpenReadOnly(filePath string) (err error) {
+	if m.file, m.writeOffset, err = openFileReadOnly(filePath, secondIndexHeaderMarker); err != nil {
+		return err
+	}
+	m.readOnly = true
+	return nil
+}
+
 func (m *SecondIndexFile) Close() error {
+	if err := flushBulkWriter(m.bulk); err != nil {
+		m.file.Close()
+		return err
+	}
+	if m.mapped != nil {
+		if err := unmapFile(m.mapped); err != nil {
+			return err
+		}
+		m.mapped = nil
+	}
 	return m.file.Close()
 }
 
+// Maps the file into memory. Nothing can be written to a mapped file.
+func (m *SecondIndexFile) Map() (err error) {
+	m.mapped, err = mapFile(m.file, m.writeOffset)
+	m.readOnly = true
+	return err
+}
+
 func (m *SecondIndexFile) Sync() error {
+	if err := m.Flush(); err != nil {
+		return err
+	}
 	return m.file.Sync()
 }
 
+// Buffers written entrys in memory, up to bufferSize bytes. They can't be read before Flush.
+func (m *SecondIndexFile) EnableBulkWrite(bufferSize int) {
+	m.bulk = newBulkWriter(m.file, bufferSize)
+}
+
+func (m *SecondIndexFile) Flush() error {
+	return flushBulkWriter(m.bulk)
+}
+
 func (m *SecondIndexFile) readHeader() error {
 	fh := NewFileHeader(secondIndexHeaderMarker)
 	if err := fh.readHeaderFromFile(m.file); err != nil {
@@ -90,54 +131,53 @@ func (m *SecondIndexFile) writeHeader() error {
 }
 
 func (m *SecondIndexFile) WriteEntry(offsets []uint64) (uint64, error) {
+	if m.readOnly {
+		return 0, ErrReadOnly
+	}
 	c := len(offsets)
 	if c == 0 {
 		return 0, ErrEmptySlice
 	}
 	returnOffset := uint64(m.writeOffset - fileHeaderSize)
 
-	bc := make([]byte, 4)
-	binary.LittleEndian.PutUint32(bc, uint32(c))
-	if _, err := m.file.WriteAt(bc, m.writeOffset); err != nil {
-		return 0, err
+	entry := make([]byte, 4+c*8)
+	binary.LittleEndian.PutUint32(entry[:4], uint32(c))
+	for i, offset := range offsets {
+		binary.LittleEndian.PutUint64(entry[4+i*8:], offset)
 	}
-	m.writeOffset += 4
-
-	b := make([]byte, 8)
-	for _, offset := range offsets {
-		binary.LittleEndian.PutUint64(b, offset)
-		if _, err := m.file.WriteAt(b, m.writeOffset); err != nil {
-			return 0, err
-		}
-		m.writeOffset += 8
+	if err := writeAt(m.file, m.bulk, entry, m.writeOffset); err != nil {
+		return 0, err
 	}
+	m.writeOffset += int64(len(entry))
 
 	return returnOffset, nil
 }
 
 func (m *SecondIndexFile) ReadEntryAt(offset uint64) ([]uint64, error) {
-	offset += uint64(fileHeaderSize)
+	offsets, _, err := readSecondIndexEntry(m.file, m.mapped, int64(offset)+fileHeaderSize)
+	return offsets, err
+}
 
-	bc := make([]byte, 4)
-	if _, err := m.file.ReadAt(bc, int64(offset)); err != nil {
-		return nil, err
+// Returns the offsets and the size of the entry in bytes.
+func readSecondIndexEntry(file *os.File, mapped []byte, offset int64) ([]uint64, int64, error) {
+	bc, err := readBytesAt(file, mapped, offset, 4)
+	if err != nil {
+		return nil, 0, err
+	}
+	count := int(binary.LittleEndian.Uint32(bc))
+	b, err := readBytesAt(file, mapped, offset+4, count*8)
+	if err != nil {
+		return nil, 0, err
 	}
-	count := uint64(binary.LittleEndian.Uint32(bc))
-	offsets := make([]uint64, count)
-	b := make([]byte, 8)
 
-	offset += 4
+	offsets := make([]uint64, count)
 	for i := range offsets {
-		if _, err := m.file.ReadAt(b, int64(offset)); err != nil {
-			return nil, err
-		}
-		offsets[i] = binary.LittleEndian.Uint64(b)
-		offset += 8
+		offsets[i] = binary.LittleEndian.Uint64(b[i*8:])
 	}
 
-	return offsets, nil
+	return offsets, 4 + int64(count)*8, nil
 }
 
 func (m *SecondIndexFile) Iterator() *SecondIndexIterator {
-	return &SecondIndexIterator{file: m.file, fileSize: m.writeOffset, offset: fileHeaderSize}
+	return &SecondIndexIterator{file: m.file, mapped: m.mapped, fileSize: m.writeOffset, offset: fileHeaderSize}
 }
@@ -18,35 +18,22 @@
 
 package main
 
-import (
-	"encoding/binary"
-	"os"
-)
+import "os"
 
 type SecondIndexIterator struct {
 	file     *os.File
+	mapped   []byte
 	fileSize int64
 	offset   int64
 }
 
 func (m *SecondIndexIterator) Next() ([]uint64, error) {
 	if m.offset < m.fileSize {
-		bc := make([]byte, 4)
-		if _, err := m.file.ReadAt(bc, m.offset); err != nil {
+		offsets, size, err := readSecondIndexEntry(m.file, m.mapped, m.offset)
+		if err != nil {
 			return nil, err
 		}
-		count := uint64(binary.LittleEndian.Uint32(bc))
-		offsets := make([]uint64, count)
-		b := make([]byte, 8)
-
-		m.offset += 4
-		for i := range offsets {
-			if _, err := m.file.ReadAt(b, m.offset); err != nil {
-				return nil, err
-			}
-			offsets[i] = binary.LittleEndian.Uint64(b)
-			m.offset += 8
-		}
+		m.offset += size
 		return offsets, nil
 	}
 	return nil, ErrIterationDone
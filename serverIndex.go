@@ -0,0 +1,50 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"time"
+)
+
+/* Server index file: Key index file where the key is hash of the game server address and time of the data entry,
+so entrys of one server within a time range are next to each other.
+Different servers may have the same hash, so found entrys must be checked against the data file.
+============================ServerIndexKey============================
+	ServerHash			= 8 byte // FNV-1a
+	Time				= 8 byte // The same as TimeIndexKey.
+============================ServerIndexKey============================
+*/
+
+const serverIndexKeySize = 8 + timeIndexKeySize
+
+var serverIndexHeaderMarker = [16]byte{'M', 'o', 'r', 'd', 'o', 'r', 'L', 'o', 'g', 's', 'D', 'B', 0x09, 0x09, 0x09, 0x09}
+
+func NewServerIndexFile() *KeyIndexFile {
+	return NewKeyIndexFile(serverIndexHeaderMarker, serverIndexKeySize)
+}
+
+func serverIndexKey(server string, t time.Time) []byte {
+	h := fnv.New64a()
+	h.Write([]byte(server))
+	key := make([]byte, 8, serverIndexKeySize)
+	binary.BigEndian.PutUint64(key, h.Sum64())
+	return append(key, timeIndexKey(t)...)
+}
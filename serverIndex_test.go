@@ -0,0 +1,125 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindByServer(t *testing.T) {
+	mldb, remove := openTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	tests := []struct {
+		server   string
+		from, to string
+		want     []string // Ordered by time.
+	}{
+		{"1.1.1.1:7777", "01.08.2020 00:00:00", "31.08.2020 00:00:00", []string{
+			"[ABC]_Bob 02.08.2020 10:00:00 192.168.1.1",
+			"Mike_Tyson 02.08.2020 19:55:11 10.0.0.2",
+			"John_Smith 02.08.2020 21:04:48 2001:db8::1",
+			"[ABC]_Carl 05.08.2020 11:00:00 192.168.1.1",
+			"john_doe 05.08.2020 23:59:59 172.16.0.1",
+		}},
+		{"2.2.2.2:7777", "01.08.2020 00:00:00", "31.08.2020 00:00:00", []string{
+			"Mike_Tyson 02.08.2020 06:26:11 10.0.2.4",
+			"Alice 05.08.2020 08:30:00 2001:db8::2",
+			"Mike_Tyson 05.08.2020 12:00:00 10.0.2.4",
+		}},
+		{"2.2.2.2:7777", "02.08.2020 06:26:11", "05.08.2020 08:30:00", []string{
+			"Mike_Tyson 02.08.2020 06:26:11 10.0.2.4",
+			"Alice 05.08.2020 08:30:00 2001:db8::2",
+		}},
+		{"2.2.2.2:7777", "02.08.2020 06:26:12", "05.08.2020 08:29:59", []string{}},
+		{"3.3.3.3:7777", "01.08.2020 00:00:00", "31.08.2020 00:00:00", []string{}},
+	}
+	for _, test := range tests {
+		from, err := time.ParseInLocation(timeFormatLayout, test.from, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to, err := time.ParseInLocation(timeFormatLayout, test.to, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := mldb.FindByServer(test.server, from, to)
+		if err != nil {
+			t.Fatalf("%s: %v", test.server, err)
+		}
+		if got := readTestEntrys(t, it); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s %s - %s: got %q, want %q", test.server, test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestFindOnServer(t *testing.T) {
+	mldb, remove := openTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	ipTests := []struct {
+		ip, server string
+		want       []string
+		wantErr    error
+	}{
+		{"10.0.2.4", "", []string{"Mike_Tyson"}, nil},
+		{"10.0.2.4", "2.2.2.2:7777", []string{"Mike_Tyson"}, nil},
+		{"10.0.2.4", "1.1.1.1:7777", nil, ErrEntryNotFound},
+		{"192.168.1.1", "1.1.1.1:7777", []string{"[ABC]_Bob", "[ABC]_Carl"}, nil},
+	}
+	for _, test := range ipTests {
+		got, err := mldb.FindNickNamesByIPOnServer(net.ParseIP(test.ip), test.server)
+		if err != test.wantErr || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s @%s: got %v, %v, want %v, %v", test.ip, test.server, got, err, test.want, test.wantErr)
+		}
+	}
+
+	fingerprintTests := []struct {
+		fingerprint, server string
+		want                []string
+		wantErr             error
+	}{
+		{"fp/dev4", "", []string{"Alice", "Mike_Tyson"}, nil},
+		{"fp/dev4", "2.2.2.2:7777", []string{"Alice", "Mike_Tyson"}, nil},
+		{"fp/dev4", "1.1.1.1:7777", []string{"Mike_Tyson"}, nil},
+		{"fp/bob", "2.2.2.2:7777", nil, ErrEntryNotFound},
+	}
+	for _, test := range fingerprintTests {
+		got, err := mldb.FindNickNamesByFingerprintOnServer(test.fingerprint, test.server)
+		if err != test.wantErr || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s @%s: got %v, %v, want %v, %v", test.fingerprint, test.server, got, err, test.want, test.wantErr)
+		}
+	}
+
+	_, subnet, err := net.ParseCIDR("10.0.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := mldb.FindByIPRange(*subnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Mike_Tyson 02.08.2020 19:55:11 10.0.0.2"}
+	if got := readTestEntrys(t, it.FilterByServer("1.1.1.1:7777")); !reflect.DeepEqual(got, want) {
+		t.Errorf("10.0.0.0/16 @1.1.1.1:7777: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+/* Time index file: Key index file where the key is time of the data entry.
+=============================TimeIndexKey=============================
+	Time				= 8 byte // Unix time with the sign bit flipped, so negative times sort first.
+=============================TimeIndexKey=============================
+*/
+
+const timeIndexKeySize = 8
+
+var timeIndexHeaderMarker = [16]byte{'M', 'o', 'r', 'd', 'o', 'r', 'L', 'o', 'g', 's', 'D', 'B', 0x08, 0x08, 0x08, 0x08}
+
+func NewTimeIndexFile() *KeyIndexFile {
+	return NewKeyIndexFile(timeIndexHeaderMarker, timeIndexKeySize)
+}
+
+func timeIndexKey(t time.Time) []byte {
+	key := make([]byte, timeIndexKeySize)
+	binary.BigEndian.PutUint64(key, uint64(t.Unix())^(1<<63))
+	return key
+}
@@ -0,0 +1,96 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimeIndexKey(t *testing.T) {
+	times := []time.Time{
+		time.Unix(-1<<40, 0),
+		time.Unix(-1, 0),
+		time.Unix(0, 0),
+		time.Unix(1, 0),
+		time.Date(2020, 8, 2, 6, 26, 11, 0, time.UTC),
+		time.Unix(1<<40, 0),
+	}
+	for i := 1; i < len(times); i++ {
+		if bytes.Compare(timeIndexKey(times[i-1]), timeIndexKey(times[i])) >= 0 {
+			t.Errorf("key of %v doesn't sort before %v", times[i-1], times[i])
+		}
+	}
+}
+
+func TestFindByTimeRange(t *testing.T) {
+	mldb, remove := openTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	tests := []struct {
+		from, to string
+		want     []string // Ordered by time.
+	}{
+		{"01.08.2020 00:00:00", "31.08.2020 00:00:00", []string{
+			"Mike_Tyson 02.08.2020 06:26:11 10.0.2.4",
+			"[ABC]_Bob 02.08.2020 10:00:00 192.168.1.1",
+			"Mike_Tyson 02.08.2020 19:55:11 10.0.0.2",
+			"John_Smith 02.08.2020 21:04:48 2001:db8::1",
+			"Alice 05.08.2020 08:30:00 2001:db8::2",
+			"[ABC]_Carl 05.08.2020 11:00:00 192.168.1.1",
+			"Mike_Tyson 05.08.2020 12:00:00 10.0.2.4",
+			"john_doe 05.08.2020 23:59:59 172.16.0.1",
+		}},
+		// Both bounds are included.
+		{"02.08.2020 10:00:00", "02.08.2020 21:04:48", []string{
+			"[ABC]_Bob 02.08.2020 10:00:00 192.168.1.1",
+			"Mike_Tyson 02.08.2020 19:55:11 10.0.0.2",
+			"John_Smith 02.08.2020 21:04:48 2001:db8::1",
+		}},
+		{"02.08.2020 10:00:01", "02.08.2020 21:04:47", []string{
+			"Mike_Tyson 02.08.2020 19:55:11 10.0.0.2",
+		}},
+		{"05.08.2020 23:59:59", "05.08.2020 23:59:59", []string{
+			"john_doe 05.08.2020 23:59:59 172.16.0.1",
+		}},
+		{"03.08.2020 00:00:00", "04.08.2020 23:59:59", []string{}},
+		{"01.01.1970 00:00:00", "02.08.2020 06:26:10", []string{}},
+		{"06.08.2020 00:00:00", "01.01.2100 00:00:00", []string{}},
+		{"05.08.2020 00:00:00", "02.08.2020 00:00:00", []string{}},
+	}
+	for _, test := range tests {
+		from, err := time.ParseInLocation(timeFormatLayout, test.from, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to, err := time.ParseInLocation(timeFormatLayout, test.to, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := mldb.FindByTimeRange(from, to)
+		if err != nil {
+			t.Fatalf("%s - %s: %v", test.from, test.to, err)
+		}
+		if got := readTestEntrys(t, it); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s - %s: got %q, want %q", test.from, test.to, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,461 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+/* Verify: Walks every file of the database and reports all problems instead of stopping at the first one.
+1. Header, version and whole file checksum of every file.
+2. Checksum of every data entry.
+3. Every first index entry has a nickname inside the heap and points to a second index entry, every second index entry
+   is referenced once and points to data entrys.
+4. Key index and folded index entrys point to existing nicknames and data entrys.
+*/
+
+type databaseFile struct {
+	name      string
+	marker    [16]byte
+	entrySize int64 // 0 if the entrys have different sizes.
+	required  bool
+	keyIndex  bool // Entrys are a key, a nickname number and a data offset, see KeyIndexFile.
+}
+
+var databaseFiles = []databaseFile{
+	{"first_index.bin", firstIndexHeaderMarker, firstIndexEntrySize, true, false},
+	{nicknameHeapPath("first_index.bin"), nicknameHeapHeaderMarker, 0, true, false},
+	{"second_index.bin", secondIndexHeaderMarker, 0, true, false},
+	{"data.bin", dataHeaderMarker, 0, true, false},
+	{"ip_index.bin", ipIndexHeaderMarker, NewIPIndexFile().entrySize, false, true},
+	{"fingerprint_index.bin", fingerprintIndexHeaderMarker, NewFingerprintIndexFile().entrySize, false, true},
+	{"time_index.bin", timeIndexHeaderMarker, NewTimeIndexFile().entrySize, false, true},
+	{"server_index.bin", serverIndexHeaderMarker, NewServerIndexFile().entrySize, false, true},
+	{"folded_index.bin", foldedIndexHeaderMarker, foldedIndexEntrySize, false, false},
+	{"cluster.bin", clusterHeaderMarker, 0, false, false},
+}
+
+// Writes the checksums of all files of a finished database.
+func SealDatabase(dirPath string) error {
+	for _, f := range databaseFiles {
+		filePath := filepath.Join(dirPath, f.name)
+		if _, err := os.Stat(filePath); os.IsNotExist(err) && !f.required {
+			continue
+		}
+		if err := WriteFileChecksum(filePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A corrupted place of the database.
+type Corruption struct {
+	File   string
+	Offset int64 // From the start of the file, -1 if the whole file is affected.
+	Reason string
+}
+
+func (m *Corruption) String() string {
+	if m.Offset < 0 {
+		return fmt.Sprintf("%s: %s", m.File, m.Reason)
+	}
+	return fmt.Sprintf("%s at offset %d: %s", m.File, m.Offset, m.Reason)
+}
+
+type VerifyResult struct {
+	NickNames   int
+	DataEntrys  int
+	Corruptions int
+}
+
+type verifier struct {
+	dirPath string
+	report  func(Corruption)
+	result  VerifyResult
+	missing bool // A required file is missing or unreadable, offsets can't be cross-checked.
+
+	nicknames     []string
+	secondOffsets []uint64 // Offset to the second index of every first index entry.
+	secondStarts  []uint64 // Offsets of the second index entrys in ascending order.
+	dataStarts    []uint64 // Offsets of the data entrys in ascending order.
+	dataOwners    []uint32 // Hashes of the nicknames stored in the data entrys.
+	badData       map[uint64]string
+}
+
+// Calls report for every corruption found. The error is only returned if the files can't be read.
+func VerifyDatabase(dirPath string, report func(Corruption)) (VerifyResult, error) {
+	v := &verifier{dirPath: dirPath, report: report, badData: make(map[uint64]string)}
+	for _, f := range databaseFiles {
+		if err := v.checkFile(f); err != nil {
+			return v.result, err
+		}
+	}
+	if v.missing {
+		return v.result, nil
+	}
+
+	if err := v.readFirstIndex(); err != nil {
+		return v.result, err
+	}
+	if err := v.walkData(); err != nil {
+		return v.result, err
+	}
+	if err := v.walkSecondIndex(); err != nil {
+		return v.result, err
+	}
+	v.checkFirstIndex()
+
+	for _, f := range databaseFiles {
+		if !f.keyIndex {
+			continue
+		}
+		if err := v.walkKeyIndex(f); err != nil {
+			return v.result, err
+		}
+	}
+	if err := v.walkFoldedIndex(); err != nil {
+		return v.result, err
+	}
+	return v.result, nil
+}
+
+func (m *verifier) corruption(file string, offset int64, format string, args ...interface{}) {
+	m.result.Corruptions++
+	m.report(Corruption{File: file, Offset: offset, Reason: fmt.Sprintf(format, args...)})
+}
+
+// Checks the header and the checksum of the whole file.
+func (m *verifier) checkFile(f databaseFile) error {
+	file, err := os.Open(filepath.Join(m.dirPath, f.name))
+	if os.IsNotExist(err) {
+		if f.required {
+			m.missing = true
+			m.corruption(f.name, -1, "file is missing")
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() < fileHeaderSize {
+		if f.required {
+			m.missing = true
+		}
+		m.corruption(f.name, 0, "file is shorter than the header")
+		return nil
+	}
+
+	fh := NewFileHeader(f.marker)
+	if err := fh.readHeaderFromFile(file); err == ErrCorrupted {
+		if f.required {
+			m.missing = true
+		}
+		m.corruption(f.name, 0, "wrong file marker")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !fh.checkVersion() {
+		if f.required {
+			m.missing = true
+		}
+		m.corruption(f.name, 16, "version %s, expected %s", formatVersion(fh.Version), formatVersion(CurrentDatabaseVersion))
+		return nil
+	}
+
+	if f.entrySize != 0 {
+		if tail := (stat.Size() - fileHeaderSize) % f.entrySize; tail != 0 {
+			m.corruption(f.name, stat.Size()-tail, "last entry is truncated")
+		}
+	}
+
+	sum, err := fileChecksum(file)
+	if err != nil {
+		return err
+	}
+	if !fh.Sealed {
+		m.corruption(f.name, 16, "file was never sealed, its checksum can't be verified")
+	} else if sum != fh.Checksum {
+		m.corruption(f.name, fileChecksumOffset, "file checksum mismatch: header %08x, contents %08x", fh.Checksum, sum)
+	}
+	return nil
+}
+
+func formatVersion(version uint32) string {
+	return fmt.Sprintf("%d.%d.%d", version>>24, version>>16&0xff, version>>8&0xff)
+}
+
+// Opens the file and returns a reader of everything after the header.
+func (m *verifier) openEntrys(name string) (*os.File, *bufio.Reader, error) {
+	file, err := os.Open(filepath.Join(m.dirPath, name))
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, bufio.NewReaderSize(io.NewSectionReader(file, fileHeaderSize, 1<<62), 1024*1024), nil
+}
+
+func (m *verifier) readFirstIndex() error {
+	file, r, err := m.openEntrys("first_index.bin")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	heap, err := ioutil.ReadFile(filepath.Join(m.dirPath, nicknameHeapPath("first_index.bin")))
+	if err != nil {
+		return err
+	}
+	heap = heap[fileHeaderSize:]
+
+	entry := make([]byte, firstIndexEntrySize)
+	for n := 0; ; n++ {
+		if _, err := io.ReadFull(r, entry); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		nicknameOffset, nicknameLength, offset := parseFirstIndexEntry(entry)
+		nickname := ""
+		if nicknameOffset+uint64(nicknameLength) > uint64(len(heap)) {
+			m.corruption("first_index.bin", fileHeaderSize+int64(n)*firstIndexEntrySize, "nickname at %d of length %d is outside of the heap", nicknameOffset, nicknameLength)
+		} else {
+			nickname = string(heap[nicknameOffset : nicknameOffset+uint64(nicknameLength)])
+		}
+		m.nicknames = append(m.nicknames, nickname)
+		m.secondOffsets = append(m.secondOffsets, offset)
+	}
+	m.result.NickNames = len(m.nicknames)
+	return nil
+}
+
+func (m *verifier) walkData() error {
+	file, r, err := m.openEntrys("data.bin")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var offset uint64
+	b := make([]byte, 2+1<<16)
+	for {
+		if _, err := io.ReadFull(r, b[:2]); err == io.EOF {
+			return nil
+		} else if err == io.ErrUnexpectedEOF {
+			m.corruption("data.bin", fileHeaderSize+int64(offset), "entry is truncated")
+			return nil
+		} else if err != nil {
+			return err
+		}
+		length := int(binary.LittleEndian.Uint16(b))
+		if length < 4+8+4 {
+			m.corruption("data.bin", fileHeaderSize+int64(offset), "invalid entry length %d, the rest of the file can't be checked", length)
+			return nil
+		}
+		if _, err := io.ReadFull(r, b[2:2+length]); err == io.EOF || err == io.ErrUnexpectedEOF {
+			m.corruption("data.bin", fileHeaderSize+int64(offset), "entry is truncated")
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		nickname, _, err := decodeDataEntry(b[2 : 2+length])
+		if err == ErrChecksumMismatch {
+			m.badData[offset] = "checksum mismatch"
+		} else if err != nil {
+			m.badData[offset] = "malformed entry"
+		}
+		m.dataStarts = append(m.dataStarts, offset)
+		m.dataOwners = append(m.dataOwners, nicknameHash(nickname))
+		m.result.DataEntrys++
+		offset += uint64(2 + length)
+	}
+}
+
+func (m *verifier) walkSecondIndex() error {
+	file, r, err := m.openEntrys("second_index.bin")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	owners := make(map[uint64]int, len(m.secondOffsets))
+	for n, offset := range m.secondOffsets {
+		owners[offset] = n
+	}
+
+	var offset uint64
+	b := make([]byte, 8)
+	for {
+		start := fileHeaderSize + int64(offset)
+		if _, err := io.ReadFull(r, b[:4]); err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			m.corruption("second_index.bin", start, "entry is truncated")
+			break
+		} else if err != nil {
+			return err
+		}
+		count := binary.LittleEndian.Uint32(b)
+		if count == 0 || uint64(count) > uint64(len(m.dataStarts)) {
+			m.corruption("second_index.bin", start, "invalid offset count %d, the rest of the file can't be checked", count)
+			break
+		}
+
+		owner := "an entry not referenced by the first index"
+		n, referenced := owners[offset]
+		if referenced {
+			owner = fmt.Sprintf("nickname %q", m.nicknames[n])
+		} else {
+			m.corruption("second_index.bin", start, "entry is not referenced by the first index")
+		}
+
+		m.secondStarts = append(m.secondStarts, offset)
+		for i := uint32(0); i < count; i++ {
+			if _, err := io.ReadFull(r, b); err == io.EOF || err == io.ErrUnexpectedEOF {
+				m.corruption("second_index.bin", start, "entry is truncated")
+				return nil
+			} else if err != nil {
+				return err
+			}
+			dataOffset := binary.LittleEndian.Uint64(b)
+			d, ok := findOffset(m.dataStarts, dataOffset)
+			if !ok {
+				m.corruption("second_index.bin", start+4+int64(i)*8, "offset %d of %s does not point to a data entry", dataOffset, owner)
+			} else if reason, ok := m.badData[dataOffset]; ok {
+				m.corruption("data.bin", fileHeaderSize+int64(dataOffset), "%s, entry %d of %s", reason, i+1, owner)
+				delete(m.badData, dataOffset)
+			} else if referenced && m.dataOwners[d] != nicknameHash(m.nicknames[n]) {
+				m.corruption("second_index.bin", start+4+int64(i)*8, "offset %d of %s points to a data entry of another nickname", dataOffset, owner)
+			}
+		}
+		offset += 4 + uint64(count)*8
+	}
+
+	// Corrupted entrys nobody points to.
+	offsets := make([]uint64, 0, len(m.badData))
+	for dataOffset := range m.badData {
+		offsets = append(offsets, dataOffset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	for _, dataOffset := range offsets {
+		m.corruption("data.bin", fileHeaderSize+int64(dataOffset), "%s", m.badData[dataOffset])
+	}
+	return nil
+}
+
+func (m *verifier) checkFirstIndex() {
+	for n, nickname := range m.nicknames {
+		offset := fileHeaderSize + int64(n)*firstIndexEntrySize
+		if nickname == "" {
+			m.corruption("first_index.bin", offset, "entry %d has an empty nickname", n)
+		} else if n > 0 && nickname <= m.nicknames[n-1] {
+			m.corruption("first_index.bin", offset, "nickname %q is not sorted after %q", nickname, m.nicknames[n-1])
+		}
+		if _, ok := findOffset(m.secondStarts, m.secondOffsets[n]); !ok {
+			m.corruption("first_index.bin", offset+9, "offset %d of nickname %q does not point to a second index entry", m.secondOffsets[n], nickname)
+		}
+	}
+}
+
+func (m *verifier) walkKeyIndex(f databaseFile) error {
+	file, r, err := m.openEntrys(f.name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	keySize := int(f.entrySize) - keyIndexEntryTailSize
+	entry := make([]byte, f.entrySize)
+	var prev []byte
+	for n := 0; ; n++ {
+		if _, err := io.ReadFull(r, entry); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		offset := fileHeaderSize + int64(n)*f.entrySize
+		key := entry[:keySize]
+		nicknameID := binary.LittleEndian.Uint32(entry[keySize:])
+		dataOffset := binary.LittleEndian.Uint64(entry[keySize+4:])
+		if prev != nil && bytes.Compare(key, prev) < 0 {
+			m.corruption(f.name, offset, "entry %d is not sorted by key", n)
+		}
+		prev = append(prev[:0], key...)
+		validID := int(nicknameID) < len(m.nicknames)
+		if !validID {
+			m.corruption(f.name, offset+int64(keySize), "entry %d points to nickname %d of %d", n, nicknameID, len(m.nicknames))
+		}
+		if d, ok := findOffset(m.dataStarts, dataOffset); !ok {
+			m.corruption(f.name, offset+int64(keySize)+4, "entry %d offset %d does not point to a data entry", n, dataOffset)
+		} else if validID && m.dataOwners[d] != nicknameHash(m.nicknames[nicknameID]) {
+			m.corruption(f.name, offset+int64(keySize), "entry %d points to a data entry of another nickname", n)
+		}
+	}
+}
+
+func (m *verifier) walkFoldedIndex() error {
+	file, r, err := m.openEntrys("folded_index.bin")
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entry := make([]byte, foldedIndexEntrySize)
+	for n := 0; ; n++ {
+		if _, err := io.ReadFull(r, entry); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if nicknameID := binary.LittleEndian.Uint32(entry); int(nicknameID) >= len(m.nicknames) {
+			m.corruption("folded_index.bin", fileHeaderSize+int64(n)*foldedIndexEntrySize, "entry %d points to nickname %d of %d", n, nicknameID, len(m.nicknames))
+		}
+	}
+}
+
+// Binary search in offsets sorted in ascending order.
+func findOffset(offsets []uint64, offset uint64) (int, bool) {
+	i := sort.Search(len(offsets), func(i int) bool { return offsets[i] >= offset })
+	return i, i < len(offsets) && offsets[i] == offset
+}
+
+func nicknameHash(nickname string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(nickname))
+	return h.Sum32()
+}
@@ -0,0 +1,132 @@
+/*
+  	MordorRpBot — https://www.blast.hk/threads/72108/
+    Copyright (C) 2020 RINWARES
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Copies the files of the database into a new temporary directory.
+func copyTestDatabase(t *testing.T, dbPath string) string {
+	copyPath, err := ioutil.TempDir("", "mordorlogs-test-copy-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(filepath.Join(dbPath, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(copyPath, file.Name()), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return copyPath
+}
+
+func TestVerifyDatabase(t *testing.T) {
+	dbPath, remove := buildTestDatabase(t, sampleTestLogs)
+	defer remove()
+
+	flipByte := func(offset int64) func(b []byte) []byte {
+		return func(b []byte) []byte {
+			b[offset] ^= 0x01
+			return b
+		}
+	}
+	tests := []struct {
+		name   string
+		file   string
+		damage func(b []byte) []byte // nil removes the file.
+		reseal bool                  // Write the file checksum again after the damage.
+		want   bool                  // A corruption of the file must be reported.
+	}{
+		{"intact", "", nil, false, false},
+		{"data entry", "data.bin", flipByte(fileHeaderSize + 10), false, true},
+		{"data entry with a new file checksum", "data.bin", flipByte(fileHeaderSize + 10), true, true},
+		{"truncated data", "data.bin", func(b []byte) []byte { return b[:len(b)-1] }, true, true},
+		{"first index", "first_index.bin", flipByte(fileHeaderSize + 1), false, true},
+		{"first index offset", "first_index.bin", flipByte(fileHeaderSize + 10), true, true},
+		{"nickname heap", nicknameHeapPath("first_index.bin"), flipByte(fileHeaderSize), false, true},
+		{"second index", "second_index.bin", flipByte(fileHeaderSize + 4), true, true},
+		{"ip index", "ip_index.bin", flipByte(fileHeaderSize + 3), false, true},
+		// The last byte of the first entry is the top of its data offset, only the walk of the key index finds it.
+		{"ip index data offset", "ip_index.bin", flipByte(fileHeaderSize + NewIPIndexFile().entrySize - 1), true, true},
+		{"fingerprint index data offset", "fingerprint_index.bin", flipByte(fileHeaderSize + NewFingerprintIndexFile().entrySize - 1), true, true},
+		{"time index data offset", "time_index.bin", flipByte(fileHeaderSize + NewTimeIndexFile().entrySize - 1), true, true},
+		{"server index data offset", "server_index.bin", flipByte(fileHeaderSize + NewServerIndexFile().entrySize - 1), true, true},
+		{"cluster", "cluster.bin", flipByte(fileHeaderSize + 1), false, true},
+		{"file marker", "time_index.bin", flipByte(0), false, true},
+		{"version", "server_index.bin", flipByte(17), false, true},
+		{"sealed flag cleared", "folded_index.bin", flipByte(16), false, true},
+		{"missing optional index", "fingerprint_index.bin", nil, false, false},
+		{"missing data", "data.bin", nil, false, true},
+	}
+	for _, test := range tests {
+		copyPath := copyTestDatabase(t, dbPath)
+		if test.file != "" {
+			filePath := filepath.Join(copyPath, test.file)
+			if test.damage == nil {
+				if err := os.Remove(filePath); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				b, err := ioutil.ReadFile(filePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filePath, test.damage(b), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.reseal {
+				if err := WriteFileChecksum(filePath); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		reported := false
+		result, err := VerifyDatabase(copyPath, func(c Corruption) {
+			if c.File == test.file {
+				reported = true
+			} else if !test.want {
+				t.Errorf("%s: %s", test.name, c.String())
+			}
+		})
+		os.RemoveAll(copyPath)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if reported != test.want || (result.Corruptions != 0) != test.want {
+			t.Errorf("%s: %d corruptions, corruption of %s reported: %v", test.name, result.Corruptions, test.file, reported)
+		}
+		if !test.want && (result.NickNames != 6 || result.DataEntrys != 8) {
+			t.Errorf("%s: %d nicknames and %d entrys checked, want 6 and 8", test.name, result.NickNames, result.DataEntrys)
+		}
+	}
+}